@@ -0,0 +1,184 @@
+package procspy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend is a way to list sockets. Connections() and AllConnections() use
+// the one set with SetBackend().
+type Backend interface {
+	// Connections lists TCP sockets. If all is false only established
+	// connections are returned. If processes is true the Proc fields are
+	// filled in, as far as possible.
+	Connections(processes, all bool) (ConnIter, error)
+}
+
+var (
+	backendMu sync.Mutex
+	backend   Backend = defaultBackend
+	backends          = map[string]Backend{}
+)
+
+// SetBackend changes the backend of the package level functions. A nil
+// backend restores the default.
+func SetBackend(b Backend) {
+	if b == nil {
+		b = defaultBackend
+	}
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	backend = b
+}
+
+// CurrentBackend returns the backend set with SetBackend().
+func CurrentBackend() Backend {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	return backend
+}
+
+// Scanner lists connections from its own backend, independent of
+// SetBackend(). Use it instead of the package level functions when you want
+// to swap the backend in a test, without affecting other tests.
+type Scanner struct {
+	// Backend is the one set with SetBackend() if nil.
+	Backend Backend
+}
+
+// NewScanner gives a Scanner for a backend.
+func NewScanner(b Backend) *Scanner {
+	return &Scanner{Backend: b}
+}
+
+func (s *Scanner) backend() Backend {
+	if s.Backend == nil {
+		return CurrentBackend()
+	}
+	return s.Backend
+}
+
+// Connections is like the package level Connections().
+func (s *Scanner) Connections(processes bool) (ConnIter, error) {
+	return s.backend().Connections(processes, false)
+}
+
+// AllConnections is like the package level AllConnections().
+func (s *Scanner) AllConnections(processes bool) (ConnIter, error) {
+	return s.backend().Connections(processes, true)
+}
+
+// Snapshot is like TakeSnapshot().
+func (s *Scanner) Snapshot(processes bool) (*Snapshot, error) {
+	now := time.Now()
+	cs, err := s.AllConnections(processes)
+	if err != nil {
+		return nil, err
+	}
+	return NewSnapshot(cs, now)
+}
+
+// RegisterBackend makes a backend available by name, for example to select
+// it from a command line flag. Registering the same name twice replaces the
+// first one.
+func RegisterBackend(name string, b Backend) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	backends[name] = b
+}
+
+// LookupBackend returns a registered backend. Names can be joined with
+// commas to chain them, such as "netlink,procfs".
+func LookupBackend(name string) (Backend, error) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	var bs []Backend
+	for _, n := range strings.Split(name, ",") {
+		if n == "" {
+			continue
+		}
+		b, ok := backends[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown backend %q", n)
+		}
+		bs = append(bs, b)
+	}
+	switch len(bs) {
+	case 0:
+		return nil, errors.New("no backend")
+	case 1:
+		return bs[0], nil
+	default:
+		return Chain(bs...), nil
+	}
+}
+
+// Backends returns the names of all registered backends, sorted.
+func Backends() []string {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	var names []string
+	for n := range backends {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain tries the backends in order, until one of them works. Only errors from
+// Connections() itself fall through to the next backend, not errors during
+// the iteration.
+func Chain(bs ...Backend) Backend {
+	return chain(bs)
+}
+
+type chain []Backend
+
+func (c chain) Connections(processes, all bool) (ConnIter, error) {
+	var errs []error
+	for _, b := range c {
+		cs, err := b.Connections(processes, all)
+		if err == nil {
+			return cs, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no backend")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// procFiles is where the processes of the first backend are, if all backends
+// have a /proc.
+func (c chain) procFiles() (procFiles, bool) {
+	var first procFiles
+	for i, b := range c {
+		pb, ok := b.(procBackend)
+		if !ok {
+			return nil, false
+		}
+		files, ok := pb.procFiles()
+		if !ok {
+			return nil, false
+		}
+		if i == 0 {
+			first = files
+		}
+	}
+	return first, first != nil
+}
+
+// Fixtures is a backend which always returns the same connections, regardless
+// of the arguments. It's designed to be used in tests.
+type Fixtures []Connection
+
+// Connections implements Backend.
+func (f Fixtures) Connections(bool, bool) (ConnIter, error) {
+	it := fixedConnIter(f)
+	return &it, nil
+}
@@ -0,0 +1,123 @@
+package procspy
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"os/exec"
+)
+
+const ssBinary = "ss"
+
+// ProcFS reads /proc. It sees every network namespace which has a process we
+// can look at. This is the default on Linux. Cache is optional.
+type ProcFS struct {
+	Cache *ProcCache
+	// FS is read instead of /proc, if set. See SetProcFS() for the layout.
+	FS fs.FS
+	// ByteOrder is of the machine FS comes from. Defaults to the byte order
+	// of this machine.
+	ByteOrder binary.ByteOrder
+}
+
+// Netlink asks the kernel over a sock_diag netlink socket, which is a lot
+// cheaper than parsing /proc/net/tcp. It only sees our own network namespace.
+// Processes are found by walking /proc, with the optional Cache.
+type Netlink struct {
+	Cache *ProcCache
+}
+
+// SS runs ss(8) from iproute2. You need to be root to find all processes.
+type SS struct{}
+
+var defaultBackend Backend = ProcFS{}
+
+func init() {
+	RegisterBackend("procfs", ProcFS{})
+	RegisterBackend("netlink", Netlink{})
+	RegisterBackend("ss", SS{})
+}
+
+// Connections implements Backend.
+func (p ProcFS) Connections(processes, all bool) (ConnIter, error) {
+	files := defaultFiles
+	if p.FS != nil {
+		files = fsFiles{p.FS}
+	}
+	return procConnections(files, p.ByteOrder, processes, all, p.Cache)
+}
+
+// procFiles is where the processes are.
+func (p ProcFS) procFiles() (procFiles, bool) {
+	if p.FS != nil {
+		return fsFiles{p.FS}, true
+	}
+	return defaultFiles, true
+}
+
+// Connections implements Backend.
+func (n Netlink) Connections(processes, all bool) (ConnIter, error) {
+	states := uint32(1 << tcpEstablished)
+	if all {
+		states = allStates
+	}
+	return n.stateConnections(processes, states)
+}
+
+// stateConnections has the kernel select on state, for Select().
+func (n Netlink) stateConnections(processes bool, states uint32) (ConnIter, error) {
+	var procs map[uint64]Proc
+	if processes {
+		var (
+			netns  = map[uint64]struct{}{}
+			tables []netTable
+			err    error
+		)
+		if procs, err = walkProcPid(defaultFiles, &netns, &tables, n.Cache); err != nil {
+			return nil, err
+		}
+	}
+
+	cs, err := sockDiag(states)
+	if err != nil {
+		return nil, err
+	}
+	ns := selfNetNS(defaultFiles)
+	for i := range cs {
+		c := &cs[i]
+		c.NetNS = ns
+		if proc, ok := procs[c.inode]; ok {
+			c.Proc = proc
+		}
+	}
+	f := fixedConnIter(cs)
+	return &f, nil
+}
+
+// procFiles is where the processes are.
+func (Netlink) procFiles() (procFiles, bool) {
+	return defaultFiles, true
+}
+
+// Connections implements Backend.
+func (SS) Connections(processes, all bool) (ConnIter, error) {
+	args := []string{
+		"-t", // only TCP
+		"-a", // listening sockets as well, we filter ourselves
+		"-n", // no name resolving
+		"-H", // no header
+	}
+	if processes {
+		args = append(args, "-p")
+	}
+	out, err := exec.Command(ssBinary, args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	f := fixedConnIter(parseSS(string(out), all))
+	return &f, nil
+}
+
+// procFiles is where the processes are, ss(8) looks at this host.
+func (SS) procFiles() (procFiles, bool) {
+	return defaultFiles, true
+}
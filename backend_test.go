@@ -0,0 +1,69 @@
+package procspy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type brokenBackend struct{}
+
+func (brokenBackend) Connections(bool, bool) (ConnIter, error) {
+	return nil, errors.New("broken")
+}
+
+func TestBackends(t *testing.T) {
+	defer SetBackend(nil)
+
+	fixtures := Fixtures{
+		testConnection(StateEstablished, "10.0.0.1:80", "10.0.0.2:4000", 0, Proc{PID: 12, Name: "nginx"}),
+	}
+	SetBackend(Chain(brokenBackend{}, fixtures))
+	cs, err := Connections(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have []Connection
+	for c := cs.Next(); c != nil; c = cs.Next() {
+		have = append(have, *c)
+	}
+	if want := []Connection(fixtures); !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+
+	SetBackend(Chain(brokenBackend{}, brokenBackend{}))
+	if _, err := Connections(true); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	SetBackend(nil)
+	if have, want := CurrentBackend(), defaultBackend; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	RegisterBackend("test-broken", brokenBackend{})
+	RegisterBackend("test-fixtures", fixtures)
+	b, err := LookupBackend("test-broken,test-fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Connections(false, false); err != nil {
+		t.Error(err)
+	}
+	if _, err := LookupBackend("test-broken,nosuch"); err == nil {
+		t.Errorf("expected an error")
+	}
+	if _, err := LookupBackend(""); err == nil {
+		t.Errorf("expected an error")
+	}
+	names := Backends()
+	for _, n := range []string{"test-broken", "test-fixtures"} {
+		found := false
+		for _, m := range names {
+			found = found || m == n
+		}
+		if !found {
+			t.Errorf("%q not in %v", n, names)
+		}
+	}
+}
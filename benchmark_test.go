@@ -2,26 +2,31 @@ package procspy
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"strconv"
 	"testing"
 )
 
 func BenchmarkParseConnectionsBaseline(b *testing.B) {
-	readFile = func(string, *bytes.Buffer) error { return nil }
+	openFile = func(procFiles, string) (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(nil)), nil }
 	benchmarkConnections(b)
-	// 333 ns/op, 0 allocs/op
+	// 445 ns/op, 8 allocs/op
 }
 
 func BenchmarkParseConnectionsFixture(b *testing.B) {
-	readFile = func(_ string, buf *bytes.Buffer) error { _, err := buf.Write(fixture); return err }
+	openFile = func(procFiles, string) (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(fixture)), nil }
 	benchmarkConnections(b)
-	// 15553 ns/op, 12 allocs/op
+	// 2079 ns/op, 8 allocs/op
 }
 
 func benchmarkConnections(b *testing.B) {
 	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		cbConnections(false)
+		cs, _ := defaultBackend.Connections(false, false)
+		for c := cs.Next(); c != nil; c = cs.Next() {
+		}
 	}
 }
 
@@ -31,3 +36,38 @@ var fixture = []byte(`  sl  local_address rem_address   st tx_queue rx_queue tr
    2: 0100007F:0019 00000000:0000 01 00000000:00000000 00:00000000 00000000     0        0 10550 1 ffff8800a729b780 100 0 0 10 0
    3: A12CF62E:E4D7 57FC1EC0:01BB 01 00000000:00000000 02:000006FA 00000000  1000        0 639474 2 ffff88007e75a740 48 4 26 10 -1
 `)
+
+func BenchmarkWalkProcPid(b *testing.B) {
+	// A synthetic /proc with 5000 processes in 50 namespaces, with 4 sockets
+	// per process.
+	root := b.TempDir()
+	var procs []fakeProc
+	for i := 0; i < 5000; i++ {
+		procs = append(procs, fakeProc{
+			pid:     1000 + i,
+			name:    "proc",
+			start:   uint64(i),
+			netns:   strconv.Itoa(i % 50),
+			sockets: 4,
+		})
+	}
+	makeProcTree(b, root, procs)
+	defer SetProcRoot(procRoot)
+	SetProcRoot(root)
+	defer SetProcWorkers(procWorkers)
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			SetProcWorkers(workers)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var (
+					netns  = map[uint64]struct{}{}
+					tables []netTable
+				)
+				walkProcPid(defaultFiles, &netns, &tables, nil)
+			}
+		})
+	}
+}
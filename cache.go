@@ -0,0 +1,175 @@
+package procspy
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errInvalidStat = errors.New("invalid /proc/<pid>/stat file")
+
+// ProcCache remembers which sockets every process has open, so repeated
+// scans only need to look at the processes which changed since the previous
+// scan. Use one ProcCache for all scans, via its Connections() method.
+//
+// A process is identified by its PID and its start time, so a recycled PID
+// is never confused with an earlier process. A cached process is rescanned
+// when the size or the mtime of its /proc/<pid>/fd directory changes (Linux
+// 6.2 and later report the number of open fds as the size), or when it was
+// last scanned longer than MaxAge ago. MaxAge is the upper bound on how stale
+// the list of sockets of a process can be, for example when a process
+// replaces one socket by another between two scans.
+type ProcCache struct {
+	// MaxAge is how long a cached process is trusted without rescanning its
+	// fds. Zero means processes are always rescanned.
+	MaxAge time.Duration
+
+	mu     sync.Mutex
+	procs  map[uint]*cachedProc
+	gen    uint64
+	events *os.File // proc connector, see Subscribe()
+}
+
+// cachedProc is a scanned process.
+type cachedProc struct {
+	procInfo
+	stamp   procStamp
+	scanned time.Time
+	gen     uint64 // last walk this process was seen in
+}
+
+// procStamp is used to decide whether a cached process is still valid.
+type procStamp struct {
+	start   uint64 // start time, in clock ticks after boot
+	fdSize  int64
+	fdMtime int64
+}
+
+// NewProcCache makes a new, empty, ProcCache.
+func NewProcCache(maxAge time.Duration) *ProcCache {
+	return &ProcCache{
+		MaxAge: maxAge,
+		procs:  map[uint]*cachedProc{},
+	}
+}
+
+// Close stops listening to process events, if Subscribe() was called.
+func (c *ProcCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.events == nil {
+		return nil
+	}
+	err := c.events.Close()
+	c.events = nil
+	return err
+}
+
+// begin is called at the start of a walk over /proc. The cache is locked
+// until end() is called.
+func (c *ProcCache) begin() {
+	c.mu.Lock()
+	c.gen++
+}
+
+// end forgets all processes which weren't seen in this walk, and unlocks the
+// cache.
+func (c *ProcCache) end() {
+	for pid, p := range c.procs {
+		if p.gen != c.gen {
+			delete(c.procs, pid)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// get returns the cached process if it's still valid, nil otherwise.
+func (c *ProcCache) get(pid uint, stamp procStamp, now time.Time) *procInfo {
+	p, ok := c.procs[pid]
+	if !ok || p.stamp != stamp || now.Sub(p.scanned) >= c.MaxAge {
+		return nil
+	}
+	p.gen = c.gen
+	return &p.procInfo
+}
+
+// put stores a freshly scanned process.
+func (c *ProcCache) put(pid uint, stamp procStamp, now time.Time, p *procInfo) {
+	c.procs[pid] = &cachedProc{
+		procInfo: *p,
+		stamp:    stamp,
+		scanned:  now,
+		gen:      c.gen,
+	}
+}
+
+// rescan scans a single process, and replaces its cached entry.
+func (c *ProcCache) rescan(pid uint) {
+	dirName := strconv.FormatUint(uint64(pid), 10)
+	stamp, err := readProcStamp(defaultFiles, dirName)
+	var p *procInfo
+	if err == nil {
+		p = scanProc(defaultFiles, dirName)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p == nil {
+		// Gone already.
+		delete(c.procs, pid)
+		return
+	}
+	c.put(pid, stamp, time.Now(), p)
+}
+
+// readProcStamp reads the start time of a process and stats its fd
+// directory. dirName is the PID.
+func readProcStamp(files procFiles, dirName string) (procStamp, error) {
+	var s procStamp
+	start, err := procStartTime(files, dirName)
+	if err != nil {
+		return s, err
+	}
+	fi, err := files.stat(dirName + "/fd")
+	if err != nil {
+		return s, err
+	}
+	s.start = start
+	s.fdSize = fi.Size()
+	s.fdMtime = fi.ModTime().UnixNano()
+	return s, nil
+}
+
+// procStartTime reads the 'starttime' field from /proc/<pid>/stat.
+func procStartTime(files procFiles, dirName string) (uint64, error) {
+	fh, err := files.open(dirName + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	var buf [512]byte
+	l, err := readSmall(fh, buf[:])
+	fh.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	// The process name can contain anything, including spaces and ')'. The
+	// fields after the name start after the last ')'.
+	b := buf[:l]
+	i := bytes.LastIndexByte(b, ')')
+	if i == -1 {
+		return 0, errInvalidStat
+	}
+	b = b[i+1:]
+	// 'starttime' is field 22, and we're now at field 3.
+	var f []byte
+	for i := 3; i <= 22; i++ {
+		if f, b = nextField(b); f == nil {
+			return 0, errInvalidStat
+		}
+	}
+	return parseDec(f), nil
+}
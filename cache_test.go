@@ -0,0 +1,101 @@
+package procspy
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestProcCache(t *testing.T) {
+	root := t.TempDir()
+	procs := []fakeProc{
+		{pid: 1, name: "init", start: 1, netns: "a"},
+		{pid: 42, name: "nginx", start: 100, netns: "a", sockets: 2},
+		{pid: 43, name: "redis", start: 200, netns: "b", sockets: 1},
+	}
+	inodes := makeProcTree(t, root, procs)
+	defer SetProcRoot(procRoot)
+	SetProcRoot(root)
+
+	walk := func(c *ProcCache) map[uint64]Proc {
+		t.Helper()
+		var (
+			netns  = map[uint64]struct{}{}
+			tables []netTable
+		)
+		res, err := walkProcPid(defaultFiles, &netns, &tables, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := len(netns), 2; have != want {
+			t.Errorf("have %d namespaces, want %d", have, want)
+		}
+		if have, want := len(tables), 4; have != want {
+			t.Errorf("have %d tables, want %d", have, want)
+		}
+		return res
+	}
+	expect := func(nginx, redis string) map[uint64]Proc {
+		return map[uint64]Proc{
+			inodes[42][0]: {PID: 42, Name: nginx},
+			inodes[42][1]: {PID: 42, Name: nginx},
+			inodes[43][0]: {PID: 43, Name: redis},
+		}
+	}
+
+	c := NewProcCache(time.Hour)
+	if have, want := walk(c), expect("nginx", "redis"); !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// Unchanged processes are not rescanned.
+	writeProc(t, root, fakeProc{pid: 42, name: "nginx2", start: 100})
+	if have, want := walk(c), expect("nginx", "redis"); !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// A recycled PID is.
+	writeProc(t, root, fakeProc{pid: 43, name: "redis2", start: 300})
+	if have, want := walk(c), expect("nginx", "redis2"); !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// Everything is rescanned after MaxAge.
+	c.MaxAge = 0
+	if have, want := walk(c), expect("nginx2", "redis2"); !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// Gone processes are forgotten.
+	if err := os.RemoveAll(root + "/1"); err != nil {
+		t.Fatal(err)
+	}
+	walk(c)
+	if _, ok := c.procs[1]; ok {
+		t.Errorf("pid 1 is still cached")
+	}
+	if have, want := len(c.procs), 2; have != want {
+		t.Errorf("have %d cached processes, want %d", have, want)
+	}
+}
+
+func TestProcStartTime(t *testing.T) {
+	defer SetProcRoot(procRoot)
+	SetProcFS(fstest.MapFS{
+		"1234/stat": {Data: []byte("1234 (we ird) name)) S 1 1 1 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 98765 0 0\n")},
+		"1235/stat": {Data: []byte("1235 (short) S 1\n")},
+	})
+	start, err := procStartTime(defaultFiles, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := start, uint64(98765); have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	if _, err := procStartTime(defaultFiles, "1235"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,31 @@
+package procspy
+
+import (
+	"errors"
+	"io"
+)
+
+var errNoCapture = errors.New("no proc captures on darwin")
+
+// Record is not supported on Darwin, which has no /proc.
+func Record(w io.Writer) error {
+	return errNoCapture
+}
+
+// Capture is a file written by Record() on Linux.
+type Capture struct{}
+
+// OpenCapture is not supported on Darwin.
+func OpenCapture(name string) (*Capture, error) {
+	return nil, errNoCapture
+}
+
+// Connections implements Backend.
+func (c *Capture) Connections(processes, all bool) (ConnIter, error) {
+	return nil, errNoCapture
+}
+
+// Close closes the file.
+func (c *Capture) Close() error {
+	return nil
+}
@@ -0,0 +1,180 @@
+package procspy
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// captureByteOrder is the file in a capture with the byte order of the
+// machine which recorded it, "LittleEndian" or "BigEndian". The addresses in
+// the tcp tables are in that order.
+const captureByteOrder = "byteorder"
+
+// Record writes the parts of /proc which procspy reads to w, as a zip file:
+// the tcp tables of every network namespace, the socket fds and ns/net links
+// of every process, and their comm, cmdline, stat, status, and cgroup files.
+// Links are stored as small files with their target. The byte order of this
+// machine is stored as well, so a capture can be replayed anywhere. Replay
+// it with OpenCapture(). You need to be root to record all processes.
+func Record(w io.Writer) error {
+	var (
+		files = defaultFiles
+		zw    = zip.NewWriter(w)
+		netns = map[uint64]struct{}{}
+	)
+	dirNames, err := files.readDirNames(".")
+	if err != nil {
+		return err
+	}
+	sort.Strings(dirNames)
+	bo, err := zw.Create(captureByteOrder)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(bo, nativeEndian.String()); err != nil {
+		return err
+	}
+
+	for _, dirName := range dirNames {
+		if _, err := strconv.ParseUint(dirName, 10, 0); err != nil {
+			// Not a number, so not a PID subdir.
+			continue
+		}
+		fdNames, err := files.readDirNames(dirName + "/fd")
+		if err != nil {
+			// Process is gone, or we don't have access.
+			continue
+		}
+		ns, ok := files.inode(dirName+"/ns/net", false)
+		if !ok {
+			continue
+		}
+		if err := recordLink(zw, dirName+"/ns/net", "net", ns); err != nil {
+			return err
+		}
+		// The fd directory, also when there are no sockets in it.
+		if _, err := zw.Create(dirName + "/fd/"); err != nil {
+			return err
+		}
+		for _, fdName := range fdNames {
+			name := dirName + "/fd/" + fdName
+			if inode, ok := files.inode(name, true); ok {
+				if err := recordLink(zw, name, "socket", inode); err != nil {
+					return err
+				}
+			}
+		}
+		for _, f := range []string{"comm", "cmdline", "stat", "status", "cgroup"} {
+			if err := recordFile(zw, files, dirName+"/"+f); err != nil {
+				return err
+			}
+		}
+		if _, ok := netns[ns]; !ok {
+			netns[ns] = struct{}{}
+			for _, f := range []string{"net/tcp", "net/tcp6"} {
+				if err := recordFile(zw, files, dirName+"/"+f); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Our own namespace, for when there are no processes.
+	if ns, ok := files.inode("self/ns/net", false); ok {
+		if err := recordLink(zw, "self/ns/net", "net", ns); err != nil {
+			return err
+		}
+	}
+	for _, f := range []string{"net/tcp", "net/tcp6"} {
+		if err := recordFile(zw, files, f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func recordLink(zw *zip.Writer, name, kind string, inode uint64) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s:[%d]", kind, inode)
+	return err
+}
+
+// recordFile copies a file. Files which are gone, or which we can't read,
+// are skipped.
+func recordFile(zw *zip.Writer, files procFiles, name string) error {
+	f, err := files.open(name)
+	if err != nil {
+		return nil
+	}
+	// Read it all first, /proc files are generated while reading.
+	b, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil
+	}
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Capture is a file written by Record(), opened for replay. It's a Backend
+// which gives the connections as they were when it was recorded.
+type Capture struct {
+	ProcFS
+	zr *zip.ReadCloser
+}
+
+// OpenCapture opens a file written by Record(). Close it when done.
+func OpenCapture(name string) (*Capture, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	order, err := readByteOrder(zr)
+	if err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &Capture{
+		ProcFS: ProcFS{FS: zr, ByteOrder: order},
+		zr:     zr,
+	}, nil
+}
+
+// readByteOrder reads the byte order of a capture. Older captures don't have
+// it, and they're in the byte order of this machine.
+func readByteOrder(fsys fs.FS) (binary.ByteOrder, error) {
+	b, err := fs.ReadFile(fsys, captureByteOrder)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nativeEndian, nil
+		}
+		return nil, err
+	}
+	switch s := strings.TrimSpace(string(b)); s {
+	case binary.LittleEndian.String():
+		return binary.LittleEndian, nil
+	case binary.BigEndian.String():
+		return binary.BigEndian, nil
+	default:
+		return nil, fmt.Errorf("unknown byte order %q", s)
+	}
+}
+
+// Close closes the file.
+func (c *Capture) Close() error {
+	return c.zr.Close()
+}
@@ -0,0 +1,128 @@
+package procspy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	root := t.TempDir()
+	makeProcTree(t, root, []fakeProc{
+		{pid: 41, name: "idle", start: 50, netns: "a"}, // no sockets
+		{pid: 42, name: "nginx", start: 100, netns: "a", sockets: 2},
+		{pid: 43, name: "redis", start: 200, netns: "a", sockets: 1},
+		{pid: 44, name: "envoy", start: 300, netns: "b", sockets: 1},
+	})
+	defer SetProcRoot(procRoot)
+	SetProcRoot(root)
+
+	list := func(b Backend, processes bool) map[Key]Connection {
+		cs, err := b.Connections(processes, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := map[Key]Connection{}
+		for c := cs.Next(); c != nil; c = cs.Next() {
+			res[c.Key()] = c.clone()
+		}
+		if err := cs.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	var (
+		live     = list(ProcFS{}, true)
+		liveSelf = list(ProcFS{}, false)
+	)
+
+	var buf bytes.Buffer
+	if err := Record(&buf); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "capture.zip")
+	if err := os.WriteFile(name, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing of the live system is used for the replay.
+	SetProcRoot(t.TempDir())
+
+	c, err := OpenCapture(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if have, want := list(c, true), live; !reflect.DeepEqual(have, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", have, want)
+	}
+	if have, want := list(c, false), liveSelf; !reflect.DeepEqual(have, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", have, want)
+	}
+	if have, want := len(live), 12; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := c.ByteOrder, nativeEndian; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+func TestCaptureBigEndian(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "capture.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, file := range []struct{ name, data string }{
+		{"byteorder", "BigEndian"},
+		{"self/ns/net", "net:[4026531840]"},
+		{"net/tcp", `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0A000001:0050 C0000201:C350 01 00000000:00000000 00:00000000 00000000     0        0 5107 1 ffff8800a6aaf040 100 0 0 10 0
+`},
+	} {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(file.data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	c, err := OpenCapture(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if have, want := c.ByteOrder, binary.ByteOrder(binary.BigEndian); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	cs, err := c.Connections(false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have []Connection
+	for c := cs.Next(); c != nil; c = cs.Next() {
+		have = append(have, c.clone())
+	}
+	want := []Connection{{
+		Transport:     "tcp",
+		LocalAddress:  net.IP{10, 0, 0, 1},
+		LocalPort:     80,
+		RemoteAddress: net.IP{192, 0, 2, 1},
+		RemotePort:    50000,
+		State:         StateEstablished,
+		NetNS:         4026531840,
+		inode:         5107,
+	}}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", have, want)
+	}
+}
@@ -0,0 +1,237 @@
+package procspy
+
+// Joining the snapshots of several hosts.
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// HostSnapshot is the snapshot of a single host, as an agent sends it to a
+// collector.
+type HostSnapshot struct {
+	Host     string     `json:"host"`
+	Snapshot *Snapshot  `json:"snapshot"`
+	NAT      []NATEntry `json:"nat,omitempty"` // see ReadNAT()
+}
+
+// ClusterEnd is one end of a connection.
+type ClusterEnd struct {
+	Host string         `json:"host,omitempty"` // "" if it's not one of the hosts
+	Addr netip.AddrPort `json:"addr"`           // as this end sees itself
+	Proc *Proc          `json:"process,omitempty"`
+}
+
+// ClusterLink is a connection between a client and a server. If only one of
+// the ends is on a known host the other end only has an address.
+type ClusterLink struct {
+	Client ClusterEnd `json:"client"`
+	Server ClusterEnd `json:"server"`
+	State  State      `json:"state"`
+	// NAT is whether the ends were matched via a NAT entry.
+	NAT bool `json:"nat,omitempty"`
+}
+
+// ClusterMap is the connections of several hosts, with both ends matched
+// where possible.
+type ClusterMap struct {
+	Links []ClusterLink `json:"links"`
+}
+
+// endpoints is a connection as one end sees it.
+type endpoints struct {
+	local, remote netip.AddrPort
+}
+
+type connRef struct {
+	host, conn int
+}
+
+// JoinHosts matches the connections of hosts. A connection from A to B on one
+// host is the same as a connection from B to A on another host, or on the
+// same host. If the NAT entry of any host says A to B is translated to C to
+// D, the server side is D to C. Connections over loopback only match on the
+// same host, in the same network namespace.
+//
+// The server is the side with a listening socket on its port, or else the
+// side which didn't connect from an ephemeral port. If both ends look like a
+// server, or neither does, the lower port is the server.
+func JoinHosts(hosts []HostSnapshot) *ClusterMap {
+	var (
+		index   = map[endpoints][]connRef{}
+		nat     = map[endpoints]endpoints{}              // client side -> server side
+		rnat    = map[endpoints]endpoints{}              // server side -> client side
+		listen  = make([]map[[2]uint64]bool, len(hosts)) // netns, port
+		matched = map[connRef]bool{}
+		m       = &ClusterMap{Links: []ClusterLink{}}
+	)
+	for h, hs := range hosts {
+		listen[h] = map[[2]uint64]bool{}
+		for i := range hs.Snapshot.Connections {
+			c := &hs.Snapshot.Connections[i]
+			if c.State == StateListen {
+				listen[h][[2]uint64{c.NetNS, uint64(c.LocalPort)}] = true
+				continue
+			}
+			if !connected(c) {
+				continue
+			}
+			e := endpoints{unzoneAP(c.Local()), unzoneAP(c.Remote())}
+			index[e] = append(index[e], connRef{h, i})
+		}
+		for _, n := range hs.NAT {
+			nat[endpoints{n.OrigSrc, n.OrigDst}] = endpoints{n.ReplySrc, n.ReplyDst}
+			rnat[endpoints{n.ReplySrc, n.ReplyDst}] = endpoints{n.OrigSrc, n.OrigDst}
+		}
+	}
+	conn := func(r connRef) *Connection {
+		return &hosts[r.host].Snapshot.Connections[r.conn]
+	}
+	end := func(r connRef) ClusterEnd {
+		c := conn(r)
+		e := ClusterEnd{Host: hosts[r.host].Host, Addr: unzoneAP(c.Local())}
+		if c.PID != 0 {
+			p := c.Proc
+			e.Proc = &p
+		}
+		return e
+	}
+	isServer := func(r connRef) bool {
+		c := conn(r)
+		if listen[r.host][[2]uint64{c.NetNS, uint64(c.LocalPort)}] {
+			return true
+		}
+		return !outbound(c)
+	}
+	// peer finds the unmatched other end of a connection, seen as e.
+	peer := func(r connRef, e endpoints) (connRef, bool) {
+		c := conn(r)
+		loopback := e.local.Addr().IsLoopback()
+		for _, p := range index[e] {
+			if p == r || matched[p] {
+				continue
+			}
+			if loopback && (p.host != r.host || conn(p).NetNS != c.NetNS) {
+				continue
+			}
+			return p, true
+		}
+		return connRef{}, false
+	}
+
+	for h := range hosts {
+		for _, r := range indexRefs(index, h) {
+			if matched[r] {
+				continue
+			}
+			matched[r] = true
+			c := conn(r)
+			l, rem := unzoneAP(c.Local()), unzoneAP(c.Remote())
+			p, ok := peer(r, endpoints{rem, l})
+			viaNAT := false
+			if !ok {
+				// We're the client, or the server, of a translated
+				// connection.
+				if n, isNAT := nat[endpoints{l, rem}]; isNAT {
+					p, ok = peer(r, endpoints{n.local, n.remote})
+				} else if n, isNAT := rnat[endpoints{l, rem}]; isNAT {
+					p, ok = peer(r, endpoints{n.local, n.remote})
+				}
+				viaNAT = ok
+			}
+			if !ok {
+				if isServer(r) {
+					m.Links = append(m.Links, ClusterLink{
+						Client: ClusterEnd{Addr: rem},
+						Server: end(r),
+						State:  c.State,
+					})
+				} else {
+					m.Links = append(m.Links, ClusterLink{
+						Client: end(r),
+						Server: ClusterEnd{Addr: rem},
+						State:  c.State,
+					})
+				}
+				continue
+			}
+			matched[p] = true
+			rs := isServer(r)
+			if rs == isServer(p) {
+				rs = c.LocalPort < conn(p).LocalPort
+			}
+			client, server := r, p
+			if rs {
+				client, server = p, r
+			}
+			m.Links = append(m.Links, ClusterLink{
+				Client: end(client),
+				Server: end(server),
+				State:  conn(client).State,
+				NAT:    viaNAT,
+			})
+		}
+	}
+
+	sort.SliceStable(m.Links, func(i, j int) bool {
+		a, b := m.Links[i], m.Links[j]
+		if a.Server.Host != b.Server.Host {
+			return a.Server.Host < b.Server.Host
+		}
+		if a.Server.Addr != b.Server.Addr {
+			return lessAP(a.Server.Addr, b.Server.Addr)
+		}
+		if a.Client.Host != b.Client.Host {
+			return a.Client.Host < b.Client.Host
+		}
+		return lessAP(a.Client.Addr, b.Client.Addr)
+	})
+	return m
+}
+
+// indexRefs gives the connections of a host, in snapshot order.
+func indexRefs(index map[endpoints][]connRef, host int) []connRef {
+	var refs []connRef
+	for _, rs := range index {
+		for _, r := range rs {
+			if r.host == host {
+				refs = append(refs, r)
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].conn < refs[j].conn })
+	return refs
+}
+
+func unzoneAP(ap netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(ap.Addr().WithZone(""), ap.Port())
+}
+
+func lessAP(a, b netip.AddrPort) bool {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c < 0
+	}
+	return a.Port() < b.Port()
+}
+
+// Graph gives the links as a graph. Processes on known hosts are nodes such
+// as "process:web1/nginx", other ends are hosts.
+func (m *ClusterMap) Graph() *Graph {
+	b := newGraphBuilder()
+	node := func(e ClusterEnd) string {
+		if e.Host == "" {
+			a := e.Addr.Addr().String()
+			return b.node(GraphNode{ID: "host:" + a, Kind: "host", Label: a})
+		}
+		name := "unknown"
+		if e.Proc != nil {
+			name = e.Proc.Name
+		}
+		label := e.Host + "/" + name
+		return b.node(GraphNode{ID: "process:" + label, Kind: "process", Label: label})
+	}
+	for _, l := range m.Links {
+		b.edges[GraphEdge{From: node(l.Client), To: node(l.Server), Port: l.Server.Addr.Port()}]++
+	}
+	return b.graph()
+}
@@ -0,0 +1,160 @@
+package procspy
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJoinHosts(t *testing.T) {
+	var (
+		nginx = Proc{PID: 42, Name: "nginx"}
+		app   = Proc{PID: 43, Name: "app"}
+		pg    = Proc{PID: 44, Name: "postgres"}
+		curl  = Proc{PID: 45, Name: "curl"}
+		snap  = func(cs ...Connection) *Snapshot { return newSnapshot(cs, time.Time{}) }
+		ap    = netip.MustParseAddrPort
+	)
+	hosts := []HostSnapshot{
+		{
+			Host: "web",
+			Snapshot: snap(
+				testConnection(StateListen, "0.0.0.0:443", "0.0.0.0:0", 1, nginx),
+				// From the internet.
+				testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.5:50123", 1, nginx),
+				// To app on the app host, which does a DNAT from port 80.
+				testConnection(StateEstablished, "10.0.0.1:40001", "10.0.0.2:80", 1, nginx),
+				// Over loopback, to itself.
+				testConnection(StateEstablished, "127.0.0.1:40002", "127.0.0.1:443", 1, curl),
+				testConnection(StateEstablished, "127.0.0.1:443", "127.0.0.1:40002", 1, nginx),
+			),
+		},
+		{
+			Host: "app",
+			Snapshot: snap(
+				testConnection(StateListen, "0.0.0.0:8080", "0.0.0.0:0", 2, app),
+				testConnection(StateEstablished, "172.17.0.2:8080", "10.0.0.1:40001", 2, app),
+				// To the database.
+				testConnection(StateEstablished, "10.0.0.2:40003", "10.0.0.3:5432", 1, app),
+				// Loopback, which doesn't go to the web host.
+				testConnection(StateEstablished, "127.0.0.1:40002", "127.0.0.1:443", 1, Proc{}),
+			),
+			NAT: []NATEntry{
+				{
+					OrigSrc:  ap("10.0.0.1:40001"),
+					OrigDst:  ap("10.0.0.2:80"),
+					ReplySrc: ap("172.17.0.2:8080"),
+					ReplyDst: ap("10.0.0.1:40001"),
+				},
+			},
+		},
+		{
+			Host: "db",
+			Snapshot: snap(
+				testConnection(StateListen, "0.0.0.0:5432", "0.0.0.0:0", 1, pg),
+				testConnection(StateEstablished, "10.0.0.3:5432", "10.0.0.2:40003", 1, pg),
+			),
+		},
+	}
+	m := JoinHosts(hosts)
+	want := &ClusterMap{
+		Links: []ClusterLink{
+			{
+				Client: ClusterEnd{Addr: ap("127.0.0.1:40002"), Host: "app"},
+				Server: ClusterEnd{Addr: ap("127.0.0.1:443")},
+				State:  StateEstablished,
+			},
+			{
+				Client: ClusterEnd{Host: "web", Addr: ap("10.0.0.1:40001"), Proc: &nginx},
+				Server: ClusterEnd{Host: "app", Addr: ap("172.17.0.2:8080"), Proc: &app},
+				State:  StateEstablished,
+				NAT:    true,
+			},
+			{
+				Client: ClusterEnd{Host: "app", Addr: ap("10.0.0.2:40003"), Proc: &app},
+				Server: ClusterEnd{Host: "db", Addr: ap("10.0.0.3:5432"), Proc: &pg},
+				State:  StateEstablished,
+			},
+			{
+				Client: ClusterEnd{Addr: ap("192.168.1.5:50123")},
+				Server: ClusterEnd{Host: "web", Addr: ap("10.0.0.1:443"), Proc: &nginx},
+				State:  StateEstablished,
+			},
+			{
+				Client: ClusterEnd{Host: "web", Addr: ap("127.0.0.1:40002"), Proc: &curl},
+				Server: ClusterEnd{Host: "web", Addr: ap("127.0.0.1:443"), Proc: &nginx},
+				State:  StateEstablished,
+			},
+		},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", m.Links, want.Links)
+	}
+	// The order of the hosts doesn't matter, also not with NAT.
+	reversed := []HostSnapshot{hosts[2], hosts[1], hosts[0]}
+	if have := JoinHosts(reversed); !reflect.DeepEqual(have, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", have.Links, want.Links)
+	}
+
+	g := m.Graph()
+	wantGraph := &Graph{
+		Nodes: []GraphNode{
+			{ID: "host:127.0.0.1", Kind: "host", Label: "127.0.0.1"},
+			{ID: "host:192.168.1.5", Kind: "host", Label: "192.168.1.5"},
+			{ID: "process:app/app", Kind: "process", Label: "app/app"},
+			{ID: "process:app/unknown", Kind: "process", Label: "app/unknown"},
+			{ID: "process:db/postgres", Kind: "process", Label: "db/postgres"},
+			{ID: "process:web/curl", Kind: "process", Label: "web/curl"},
+			{ID: "process:web/nginx", Kind: "process", Label: "web/nginx"},
+		},
+		Edges: []GraphEdge{
+			{From: "host:192.168.1.5", To: "process:web/nginx", Port: 443, Count: 1},
+			{From: "process:app/app", To: "process:db/postgres", Port: 5432, Count: 1},
+			{From: "process:app/unknown", To: "host:127.0.0.1", Port: 443, Count: 1},
+			{From: "process:web/curl", To: "process:web/nginx", Port: 443, Count: 1},
+			{From: "process:web/nginx", To: "process:app/app", Port: 8080, Count: 1},
+		},
+	}
+	if !reflect.DeepEqual(g, wantGraph) {
+		t.Errorf("have\n%+v\nwant\n%+v", g, wantGraph)
+	}
+}
+
+func TestJoinHostsPeers(t *testing.T) {
+	var (
+		agent = Proc{PID: 42, Name: "agent"}
+		snap  = func(cs ...Connection) *Snapshot { return newSnapshot(cs, time.Time{}) }
+		ap    = netip.MustParseAddrPort
+	)
+	// Both ends listen on the port they connect from, so both look like the
+	// server.
+	hosts := []HostSnapshot{
+		{
+			Host: "a",
+			Snapshot: snap(
+				testConnection(StateListen, "0.0.0.0:7946", "0.0.0.0:0", 1, agent),
+				testConnection(StateEstablished, "10.0.0.1:7946", "10.0.0.2:7946", 1, agent),
+			),
+		},
+		{
+			Host: "b",
+			Snapshot: snap(
+				testConnection(StateListen, "0.0.0.0:7946", "0.0.0.0:0", 1, agent),
+				testConnection(StateEstablished, "10.0.0.2:7946", "10.0.0.1:7946", 1, agent),
+			),
+		},
+	}
+	want := &ClusterMap{
+		Links: []ClusterLink{
+			{
+				Client: ClusterEnd{Host: "a", Addr: ap("10.0.0.1:7946"), Proc: &agent},
+				Server: ClusterEnd{Host: "b", Addr: ap("10.0.0.2:7946"), Proc: &agent},
+				State:  StateEstablished,
+			},
+		},
+	}
+	if have := JoinHosts(hosts); !reflect.DeepEqual(have, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", have.Links, want.Links)
+	}
+}
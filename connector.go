@@ -0,0 +1,125 @@
+package procspy
+
+// Decoding of the process events from the kernel proc connector. See
+// include/uapi/linux/connector.h and include/uapi/linux/cn_proc.h.
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	cnIdxProc = 1
+	cnValProc = 1
+
+	procCnMcastListen = 1
+
+	procEventNone = 0x00000000
+	procEventFork = 0x00000001
+	procEventExec = 0x00000002
+	procEventExit = 0x80000000
+
+	nlmsgHdrLen     = 16 // struct nlmsghdr
+	cnMsgLen        = 20 // struct cn_msg
+	procEventHdrLen = 16 // struct proc_event, up to the event data
+)
+
+var errShortProcEvent = errors.New("short proc connector message")
+
+// procEvent is a single event from the proc connector. pid is the thread ID,
+// tgid the process ID.
+type procEvent struct {
+	what       uint32
+	pid, tgid  uint
+	parentTGID uint   // fork only
+	err        uint32 // the ack of our listen request (procEventNone) only
+}
+
+// parseProcEvents decodes all proc events in a netlink datagram. The kernel
+// uses its own byte order. Messages for other connectors and event types we
+// don't use are skipped.
+func parseProcEvents(b []byte, order binary.ByteOrder) ([]procEvent, error) {
+	var res []procEvent
+	for len(b) > 0 {
+		if len(b) < nlmsgHdrLen {
+			return res, errShortProcEvent
+		}
+		l := int(order.Uint32(b))
+		if l < nlmsgHdrLen || l > len(b) {
+			return res, errShortProcEvent
+		}
+		msg := b[nlmsgHdrLen:l]
+		// Messages are 4-byte aligned.
+		if l = (l + 3) &^ 3; l > len(b) {
+			l = len(b)
+		}
+		b = b[l:]
+
+		if len(msg) < cnMsgLen {
+			return res, errShortProcEvent
+		}
+		if order.Uint32(msg) != cnIdxProc || order.Uint32(msg[4:]) != cnValProc {
+			continue
+		}
+		data := msg[cnMsgLen:]
+		if dl := int(order.Uint16(msg[16:])); dl < len(data) {
+			data = data[:dl]
+		}
+		if len(data) < procEventHdrLen {
+			return res, errShortProcEvent
+		}
+		e := procEvent{
+			what: order.Uint32(data),
+		}
+		data = data[procEventHdrLen:]
+		var need int
+		switch e.what {
+		case procEventNone:
+			need = 4
+		case procEventFork:
+			need = 16
+		case procEventExec:
+			need = 8
+		case procEventExit:
+			need = 8
+		default:
+			continue
+		}
+		if len(data) < need {
+			return res, errShortProcEvent
+		}
+		switch e.what {
+		case procEventNone:
+			e.err = order.Uint32(data)
+		case procEventFork:
+			// parent_pid, parent_tgid, child_pid, child_tgid
+			e.parentTGID = uint(order.Uint32(data[4:]))
+			e.pid = uint(order.Uint32(data[8:]))
+			e.tgid = uint(order.Uint32(data[12:]))
+		case procEventExec, procEventExit:
+			// process_pid, process_tgid, ...
+			e.pid = uint(order.Uint32(data))
+			e.tgid = uint(order.Uint32(data[4:]))
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+// handleProcEvent updates the cache for a single event. Exited processes are
+// forgotten, and processes which exec()ed are rescanned straight away.
+// Forked processes will be scanned by the next walk.
+func (c *ProcCache) handleProcEvent(e procEvent) {
+	if e.pid != e.tgid {
+		// Not the main thread.
+		return
+	}
+	switch e.what {
+	case procEventExit:
+		c.mu.Lock()
+		delete(c.procs, e.tgid)
+		c.mu.Unlock()
+	case procEventExec:
+		c.rescan(e.tgid)
+	}
+}
@@ -0,0 +1,10 @@
+package procspy
+
+import (
+	"errors"
+)
+
+// Subscribe is not supported on Darwin, which has no proc connector.
+func (c *ProcCache) Subscribe() error {
+	return errors.New("no proc connector on darwin")
+}
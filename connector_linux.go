@@ -0,0 +1,119 @@
+package procspy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// procAckTimeout is how long Subscribe() waits for the kernel to answer.
+const procAckTimeout = 5 * time.Second
+
+// Subscribe listens to process events from the kernel proc connector. Exited
+// processes are removed from the cache immediately, and processes are
+// rescanned right after they exec(). Subscribing needs CAP_NET_ADMIN. If it
+// returns an error the cache still works, but only by polling /proc. Events
+// which arrive while a walk holds the cache might be dropped by the kernel,
+// which is harmless. Subscribe waits until the kernel accepts the request.
+// Call Close() to stop listening.
+func (c *ProcCache) Subscribe() error {
+	fd, err := syscall.Socket(
+		syscall.AF_NETLINK,
+		syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK,
+		syscall.NETLINK_CONNECTOR,
+	)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: cnIdxProc,
+	}); err != nil {
+		syscall.Close(fd)
+		return os.NewSyscallError("bind", err)
+	}
+
+	// struct nlmsghdr, struct cn_msg, enum proc_cn_mcast_op
+	msg := make([]byte, nlmsgHdrLen+cnMsgLen+4)
+	nativeEndian.PutUint32(msg, uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:], syscall.NLMSG_DONE)
+	cn := msg[nlmsgHdrLen:]
+	nativeEndian.PutUint32(cn, cnIdxProc)
+	nativeEndian.PutUint32(cn[4:], cnValProc)
+	nativeEndian.PutUint16(cn[16:], 4)
+	nativeEndian.PutUint32(cn[cnMsgLen:], procCnMcastListen)
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+	}); err != nil {
+		syscall.Close(fd)
+		return os.NewSyscallError("sendto", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "proc connector")
+	f.SetReadDeadline(time.Now().Add(procAckTimeout))
+	if err := readProcAck(f, nativeEndian, c.handleProcEvent); err != nil {
+		f.Close()
+		return err
+	}
+	f.SetReadDeadline(time.Time{})
+	c.mu.Lock()
+	if c.events != nil {
+		c.events.Close()
+	}
+	c.events = f
+	c.mu.Unlock()
+	go c.readProcEvents(f)
+	return nil
+}
+
+// readProcAck reads until the answer to our listen request. Events which
+// arrive before it are handled.
+func readProcAck(r io.Reader, order binary.ByteOrder, handle func(procEvent)) error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.ENOBUFS) {
+				continue
+			}
+			return fmt.Errorf("proc connector: %w", err)
+		}
+		es, _ := parseProcEvents(buf[:n], order)
+		for _, e := range es {
+			if e.what == procEventNone {
+				if e.err != 0 {
+					return fmt.Errorf("proc connector: %w", syscall.Errno(e.err))
+				}
+				return nil
+			}
+			handle(e)
+		}
+	}
+}
+
+// readProcEvents handles events until the socket is closed.
+func (c *ProcCache) readProcEvents(f *os.File) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.ENOBUFS) {
+				// We were too slow and lost some events.
+				continue
+			}
+			return
+		}
+		es, _ := parseProcEvents(buf[:n], nativeEndian)
+		for _, e := range es {
+			if e.what == procEventNone {
+				// Subscribe() had the ack already.
+				continue
+			}
+			c.handleProcEvent(e)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package procspy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"syscall"
+	"testing"
+)
+
+// procAck is the answer to a listen request, in little endian.
+func procAck(errno uint32) []byte {
+	msg := make([]byte, nlmsgHdrLen+cnMsgLen+procEventHdrLen+4)
+	binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+	cn := msg[nlmsgHdrLen:]
+	binary.LittleEndian.PutUint32(cn, cnIdxProc)
+	binary.LittleEndian.PutUint32(cn[4:], cnValProc)
+	binary.LittleEndian.PutUint16(cn[16:], procEventHdrLen+4)
+	binary.LittleEndian.PutUint32(cn[cnMsgLen+procEventHdrLen:], errno)
+	return msg
+}
+
+// datagrams reads one datagram at a time.
+type datagrams [][]byte
+
+func (d *datagrams) Read(b []byte) (int, error) {
+	if len(*d) == 0 {
+		return 0, syscall.EAGAIN
+	}
+	n := copy(b, (*d)[0])
+	*d = (*d)[1:]
+	return n, nil
+}
+
+func TestReadProcAck(t *testing.T) {
+	events, err := hex.DecodeString(recordedProcEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handled []procEvent
+	handle := func(e procEvent) { handled = append(handled, e) }
+
+	d := datagrams{events, procAck(0)}
+	if err := readProcAck(&d, binary.LittleEndian, handle); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(handled), 3; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	d = datagrams{procAck(uint32(syscall.EPERM))}
+	if err := readProcAck(&d, binary.LittleEndian, handle); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("have %v", err)
+	}
+
+	// No answer.
+	d = datagrams{bytes.Repeat([]byte{0}, 4)}
+	if err := readProcAck(&d, binary.LittleEndian, handle); err == nil {
+		t.Errorf("no error")
+	}
+}
@@ -0,0 +1,92 @@
+package procspy
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Three netlink messages in one datagram, as recorded on amd64: an exec of
+// 1234, the exit of thread 1235 of process 1234, and a fork of 1236 by 1.
+const recordedProcEvents = "3c0000000300000000000000000000000100000001000000070000000000000018000000020000000300000015cd5b0700000000d2040000d2040000" +
+	"4c0000000300000000000000000000000100000001000000070000000000000028000000000000800300000015cd5b0700000000d3040000d204000000000000110000000100000001000000" +
+	"440000000300000000000000000000000100000001000000070000000000000020000000010000000300000015cd5b07000000000100000001000000d4040000d4040000"
+
+func TestParseProcEvents(t *testing.T) {
+	b, err := hex.DecodeString(recordedProcEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := parseProcEvents(b, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []procEvent{
+		{what: procEventExec, pid: 1234, tgid: 1234},
+		{what: procEventExit, pid: 1235, tgid: 1234},
+		{what: procEventFork, pid: 1236, tgid: 1236, parentTGID: 1},
+	}
+	if !reflect.DeepEqual(es, expected) {
+		t.Errorf("have\n%+v\nwant\n%+v", es, expected)
+	}
+
+	// Truncated datagrams give the events up to the broken message.
+	es, err = parseProcEvents(b[:len(b)-3], binary.LittleEndian)
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+	if !reflect.DeepEqual(es, expected[:2]) {
+		t.Errorf("have\n%+v\nwant\n%+v", es, expected[:2])
+	}
+	for i := range b {
+		// Never panics.
+		parseProcEvents(b[:i], binary.LittleEndian)
+	}
+}
+
+func TestProcCacheEvents(t *testing.T) {
+	root := t.TempDir()
+	inodes := makeProcTree(t, root, []fakeProc{
+		{pid: 42, name: "nginx", start: 100, netns: "a", sockets: 1},
+		{pid: 43, name: "redis", start: 200, netns: "a", sockets: 1},
+	})
+	defer SetProcRoot(procRoot)
+	SetProcRoot(root)
+
+	c := NewProcCache(time.Hour)
+	var (
+		netns  = map[uint64]struct{}{}
+		tables []netTable
+	)
+	if _, err := walkProcPid(defaultFiles, &netns, &tables, c); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(c.procs), 2; have != want {
+		t.Fatalf("have %d cached processes, want %d", have, want)
+	}
+
+	// Exits of threads are ignored.
+	c.handleProcEvent(procEvent{what: procEventExit, pid: 44, tgid: 43})
+	if _, ok := c.procs[43]; !ok {
+		t.Errorf("pid 43 is gone")
+	}
+	c.handleProcEvent(procEvent{what: procEventExit, pid: 43, tgid: 43})
+	if _, ok := c.procs[43]; ok {
+		t.Errorf("pid 43 is still cached")
+	}
+
+	writeProc(t, root, fakeProc{pid: 42, name: "nginx-worker", start: 100})
+	c.handleProcEvent(procEvent{what: procEventExec, pid: 42, tgid: 42})
+	p, ok := c.procs[42]
+	if !ok {
+		t.Fatalf("pid 42 is gone")
+	}
+	if have, want := p.name, "nginx-worker"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+	if have, want := p.inodes, inodes[42]; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
@@ -0,0 +1,17 @@
+package procspy
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// nativeEndian is the byte order of the machine we're running on. The kernel
+// uses it in /proc/net/tcp{,6} and in netlink messages.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	var x uint16 = 1
+	if (*[2]byte)(unsafe.Pointer(&x))[0] == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
@@ -17,4 +17,7 @@ func Example() {
 	for c := cs.Next(); c != nil; c = cs.Next() {
 		fmt.Printf(" - %v\n", c)
 	}
+	if err := cs.Err(); err != nil {
+		panic(err)
+	}
 }
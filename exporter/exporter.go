@@ -0,0 +1,292 @@
+// exporter serves connection metrics for Prometheus. Every scrape of
+// /metrics scans the connections.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alicebob/procspy"
+)
+
+var (
+	listen    = flag.String("listen", ":9666", "address to listen on")
+	backend   = flag.String("backend", "", "backend(s) to scan with, such as \"netlink,procfs\"")
+	labels    = flag.String("labels", "state,process,transport,direction", "labels of procspy_connections, see procspy.ParseGroupFields")
+	maxValues = flag.Int("max-values", 20, "values per label, the connections with other values get the value \"other\". 0 is no limit")
+	snmp      = flag.String("snmp", "/proc/net/snmp", "file with the TCP retransmits, \"\" to skip them")
+)
+
+// other is the label value for everything over the limit.
+const other = "other"
+
+func main() {
+	flag.Parse()
+	e, err := newExporter(*backend, *labels, *maxValues, *snmp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exporter: %s\n", err)
+		os.Exit(2)
+	}
+	http.Handle("/metrics", e)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
+
+type exporter struct {
+	scanner   *procspy.Scanner
+	fields    []procspy.GroupField
+	processes bool
+	maxValues int
+	snmp      string
+
+	mu     sync.Mutex // one scrape at a time
+	errors int
+}
+
+func newExporter(backend, labels string, maxValues int, snmp string) (*exporter, error) {
+	e := &exporter{
+		scanner:   &procspy.Scanner{},
+		maxValues: maxValues,
+		snmp:      snmp,
+		// the listen queue has process labels
+		processes: true,
+	}
+	if backend != "" {
+		b, err := procspy.LookupBackend(backend)
+		if err != nil {
+			return nil, err
+		}
+		e.scanner.Backend = b
+	}
+	fields, err := procspy.ParseGroupFields(labels)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, f := range fields {
+		if seen[f.Name()] {
+			return nil, fmt.Errorf("duplicate label %q", f.Name())
+		}
+		seen[f.Name()] = true
+	}
+	e.fields = fields
+	return e, nil
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var b bytes.Buffer
+	e.write(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// write does a scan, and writes all metrics.
+func (e *exporter) write(w io.Writer) {
+	start := time.Now()
+	snap, err := e.scanner.Snapshot(e.processes)
+	took := time.Since(start)
+	if err != nil {
+		log.Printf("scan: %s", err)
+		e.errors++
+	} else {
+		e.writeConnections(w, snap.Connections)
+		e.writeListenQueues(w, snap.Connections)
+	}
+
+	if e.snmp != "" {
+		if n, ok := retransSegs(e.snmp); ok {
+			fmt.Fprintf(w, "# HELP procspy_tcp_retransmitted_segments_total TCP segments retransmitted, from %s.\n", e.snmp)
+			fmt.Fprintf(w, "# TYPE procspy_tcp_retransmitted_segments_total counter\n")
+			fmt.Fprintf(w, "procspy_tcp_retransmitted_segments_total %d\n", n)
+		}
+	}
+	fmt.Fprintf(w, "# HELP procspy_scan_duration_seconds How long the last scan took.\n")
+	fmt.Fprintf(w, "# TYPE procspy_scan_duration_seconds gauge\n")
+	fmt.Fprintf(w, "procspy_scan_duration_seconds %g\n", took.Seconds())
+	fmt.Fprintf(w, "# HELP procspy_scan_errors_total Failed scans.\n")
+	fmt.Fprintf(w, "# TYPE procspy_scan_errors_total counter\n")
+	fmt.Fprintf(w, "procspy_scan_errors_total %d\n", e.errors)
+}
+
+func (e *exporter) writeConnections(w io.Writer, cs []procspy.Connection) {
+	groups, _ := e.scanner.GroupBy(procspy.SliceIter(cs), e.fields...)
+	groups = limitValues(groups, e.maxValues)
+	names := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		names[i] = f.Name()
+	}
+	fmt.Fprintf(w, "# HELP procspy_connections TCP connections.\n")
+	fmt.Fprintf(w, "# TYPE procspy_connections gauge\n")
+	for _, g := range groups {
+		fmt.Fprintf(w, "procspy_connections%s %d\n", labelSet(names, g.Values), g.Count)
+	}
+}
+
+// writeListenQueues writes how many connections wait for an accept(), per
+// listening socket. The labels are limited as with procspy_connections.
+func (e *exporter) writeListenQueues(w io.Writer, cs []procspy.Connection) {
+	fmt.Fprintf(w, "# HELP procspy_listen_queue_length Connections waiting to be accepted.\n")
+	fmt.Fprintf(w, "# TYPE procspy_listen_queue_length gauge\n")
+	// Sockets with SO_REUSEPORT share the port, so add them up. Count is the
+	// number of sockets, RecvQ the queue.
+	type key struct{ port, process string }
+	var (
+		queues = map[key]*procspy.Group{}
+		keys   []key
+	)
+	for i := range cs {
+		c := &cs[i]
+		if c.State != procspy.StateListen {
+			continue
+		}
+		k := key{port: strconv.Itoa(int(c.LocalPort)), process: c.Name}
+		g, ok := queues[k]
+		if !ok {
+			g = &procspy.Group{Values: []string{k.port, k.process}}
+			queues[k] = g
+			keys = append(keys, k)
+		}
+		g.Count++
+		g.RecvQ += uint64(c.RecvQ)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].port != keys[j].port {
+			return keys[i].port < keys[j].port
+		}
+		return keys[i].process < keys[j].process
+	})
+	groups := make([]procspy.Group, 0, len(keys))
+	for _, k := range keys {
+		groups = append(groups, *queues[k])
+	}
+	for _, g := range limitValues(groups, e.maxValues) {
+		fmt.Fprintf(w, "procspy_listen_queue_length%s %d\n", labelSet([]string{"lport", "process"}, g.Values), g.RecvQ)
+	}
+}
+
+// limitValues keeps the maxValues most common values of every label, and
+// merges the groups with other values. 0 is no limit.
+func limitValues(groups []procspy.Group, maxValues int) []procspy.Group {
+	if maxValues <= 0 || len(groups) == 0 {
+		return groups
+	}
+	n := len(groups[0].Values)
+	keep := make([]map[string]bool, n)
+	for i := range keep {
+		counts := map[string]int{}
+		for _, g := range groups {
+			counts[g.Values[i]] += g.Count
+		}
+		values := make([]string, 0, len(counts))
+		for v := range counts {
+			values = append(values, v)
+		}
+		sort.Slice(values, func(a, b int) bool {
+			if counts[values[a]] != counts[values[b]] {
+				return counts[values[a]] > counts[values[b]]
+			}
+			return values[a] < values[b]
+		})
+		if len(values) > maxValues {
+			values = values[:maxValues]
+		}
+		keep[i] = map[string]bool{}
+		for _, v := range values {
+			keep[i][v] = true
+		}
+	}
+
+	var (
+		merged = map[string]*procspy.Group{}
+		res    []procspy.Group
+		order  []string
+	)
+	for _, g := range groups {
+		values := make([]string, n)
+		for i, v := range g.Values {
+			if !keep[i][v] {
+				v = other
+			}
+			values[i] = v
+		}
+		k := strings.Join(values, "\x00")
+		m, ok := merged[k]
+		if !ok {
+			m = &procspy.Group{Values: values}
+			merged[k] = m
+			order = append(order, k)
+		}
+		m.Count += g.Count
+		m.RecvQ += g.RecvQ
+		m.SendQ += g.SendQ
+	}
+	for _, k := range order {
+		res = append(res, *merged[k])
+	}
+	return res
+}
+
+// labelSet formats labels as `{a="1",b="2"}`.
+func labelSet(names, values []string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// retransSegs reads RetransSegs from the "Tcp:" lines of /proc/net/snmp. The
+// first line has the names, the second the values.
+func retransSegs(filename string) (uint64, bool) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	return parseSNMP(f, "Tcp:", "RetransSegs")
+}
+
+func parseSNMP(r io.Reader, prefix, name string) (uint64, bool) {
+	var names []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || fields[0] != prefix {
+			continue
+		}
+		if names == nil {
+			names = fields
+			continue
+		}
+		for i, n := range names {
+			if n == name && i < len(fields) {
+				v, err := strconv.ParseUint(fields[i], 10, 64)
+				return v, err == nil
+			}
+		}
+		return 0, false
+	}
+	return 0, false
+}
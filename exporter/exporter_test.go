@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/procspy"
+)
+
+func conn(state procspy.State, local, remote string, p procspy.Proc) procspy.Connection {
+	l, r := netip.MustParseAddrPort(local), netip.MustParseAddrPort(remote)
+	return procspy.Connection{
+		Transport:     "tcp",
+		LocalAddress:  net.IP(l.Addr().AsSlice()),
+		LocalPort:     l.Port(),
+		RemoteAddress: net.IP(r.Addr().AsSlice()),
+		RemotePort:    r.Port(),
+		State:         state,
+		Proc:          p,
+	}
+}
+
+func TestScrape(t *testing.T) {
+	nginx := procspy.Proc{PID: 42, Name: "nginx"}
+	listen := conn(procspy.StateListen, "0.0.0.0:443", "0.0.0.0:0", nginx)
+	listen.RecvQ = 3
+	procspy.RegisterBackend("test-exporter", procspy.Fixtures{
+		listen,
+		conn(procspy.StateEstablished, "10.0.0.1:443", "10.0.0.2:50000", nginx),
+		conn(procspy.StateEstablished, "10.0.0.1:443", "10.0.0.3:50001", nginx),
+		conn(procspy.StateEstablished, "10.0.0.1:443", "10.0.0.4:50002", nginx),
+		conn(procspy.StateTimeWait, "10.0.0.1:443", "10.0.0.5:50003", procspy.Proc{}),
+	})
+	e, err := newExporter("test-exporter", "state,process,rport", 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(e)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if have, want := res.Header.Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have []string
+	for _, l := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(l, "procspy_") && !strings.HasPrefix(l, "procspy_scan_duration") {
+			have = append(have, l)
+		}
+	}
+	want := []string{
+		`procspy_connections{state="ESTABLISHED",process="nginx",rport="50000"} 1`,
+		`procspy_connections{state="ESTABLISHED",process="nginx",rport="other"} 2`,
+		`procspy_connections{state="LISTEN",process="nginx",rport="0"} 1`,
+		`procspy_connections{state="other",process="",rport="other"} 1`,
+		`procspy_listen_queue_length{lport="443",process="nginx"} 3`,
+		`procspy_scan_errors_total 0`,
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have\n%s\nwant\n%s", strings.Join(have, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := newExporter("", "nosuch", 0, ""); err == nil {
+		t.Errorf("no error for an unknown label")
+	}
+	if _, err := newExporter("", "state,raddr/16,raddr/24", 0, ""); err == nil {
+		t.Errorf("no error for a duplicate label")
+	}
+}
+
+func TestListenQueues(t *testing.T) {
+	var (
+		nginx = procspy.Proc{PID: 42, Name: "nginx"}
+		cs    []procspy.Connection
+	)
+	for _, port := range []string{"80", "443", "443", "8080"} {
+		c := conn(procspy.StateListen, "0.0.0.0:"+port, "0.0.0.0:0", nginx)
+		c.RecvQ = 2
+		cs = append(cs, c)
+	}
+	e := &exporter{maxValues: 1}
+	var b strings.Builder
+	e.writeListenQueues(&b, cs)
+	have := strings.Split(strings.TrimSpace(b.String()), "\n")[2:]
+	want := []string{
+		`procspy_listen_queue_length{lport="443",process="nginx"} 4`,
+		`procspy_listen_queue_length{lport="other",process="nginx"} 4`,
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have\n%s\nwant\n%s", strings.Join(have, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLabelSet(t *testing.T) {
+	if have, want := labelSet([]string{"a", "b"}, []string{`x"y`, "1\\2\n"}), `{a="x\"y",b="1\\2\n"}`; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+func TestParseSNMP(t *testing.T) {
+	in := `Ip: Forwarding DefaultTTL
+Ip: 1 64
+Tcp: RtoAlgorithm RtoMin ActiveOpens RetransSegs InErrs
+Tcp: 1 200 1234 567 0
+Udp: InDatagrams
+Udp: 12
+`
+	n, ok := parseSNMP(strings.NewReader(in), "Tcp:", "RetransSegs")
+	if !ok || n != 567 {
+		t.Errorf("have %d %t, want 567", n, ok)
+	}
+	if _, ok := parseSNMP(strings.NewReader(in), "Tcp:", "Nosuch"); ok {
+		t.Errorf("found a missing field")
+	}
+}
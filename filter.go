@@ -0,0 +1,557 @@
+package procspy
+
+// Filter expressions, such as "port 443 and process nginx".
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Filter is a compiled filter expression. Use Match() on single connections,
+// or Select() to let the backend do as much of the work as it can.
+//
+// The grammar is:
+//
+//	expr := and {"or" and}
+//	and  := not {"and" not}
+//	not  := "not" not | "(" expr ")" | primitive
+//
+// with these primitives:
+//
+//	port N[-M]      local or remote port, or a range of ports
+//	lport N[-M]     local port
+//	rport N[-M]     remote port
+//	net CIDR        local or remote address is in the network, or is the address
+//	lnet CIDR       local address
+//	rnet CIDR       remote address
+//	state S         such as "established", "time_wait", or "time-wait"
+//	listening       same as "state listen"
+//	inbound         connections to us, see below
+//	outbound        connections from us
+//	tcp, udp        the transport
+//	netns N         the inode of the network namespace
+//	process NAME    the process name, which can be a glob such as "nginx*"
+//	pid N           the process ID
+//	uid N           the owner of the process
+//	container ID    the container ID from the cgroup of the process. A prefix
+//	                of the ID is enough.
+//
+// The direction is a guess from the ports: a port of 32768 and up is
+// ephemeral, so a connection from an ephemeral port to a non-ephemeral port
+// is outbound. If both or neither are the lower port is the server.
+//
+// The process primitives only match when the process is known. uid and
+// container read /proc: Select() uses the /proc of the backend, and is an
+// error with backends which have none, such as Fixtures. Match() and Iter()
+// read /proc of this host.
+type Filter struct {
+	expr  string
+	root  filterNode
+	files procFiles // for uid and container, nil is defaultFiles
+}
+
+// errNoProc is when a filter needs /proc, and there is none.
+var errNoProc = errors.New("uid and container need a backend with /proc")
+
+// FilterError is an invalid filter expression.
+type FilterError struct {
+	Offset int // in bytes
+	Msg    string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Msg)
+}
+
+// filterNode is a compiled (sub)expression.
+type filterNode struct {
+	match func(*Connection, *procCache) bool
+	// procs is whether it looks at the process.
+	procs bool
+	// proc is whether it reads /proc, for uid and container.
+	proc bool
+	// states has bit 1<<state set for every state it can match.
+	states uint32
+}
+
+const (
+	allStates      = ^uint32(0)
+	ephemeralPorts = 32768
+)
+
+// ParseFilter compiles a filter expression. The empty expression matches
+// everything.
+func ParseFilter(expr string) (*Filter, error) {
+	p := &filterParser{toks: tokenize(expr), end: len(expr)}
+	if len(p.toks) == 0 {
+		return &Filter{
+			root: filterNode{
+				match:  func(*Connection, *procCache) bool { return true },
+				states: allStates,
+			},
+		}, nil
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, &FilterError{Offset: t.offset, Msg: fmt.Sprintf("unexpected %q, expected \"and\" or \"or\"", t.s)}
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// String gives the expression.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match is whether the connection matches.
+func (f *Filter) Match(c *Connection) bool {
+	return f.root.match(c, newProcCache(f.files))
+}
+
+// Processes is whether the filter looks at processes, so they need to be
+// looked up.
+func (f *Filter) Processes() bool {
+	return f.root.procs
+}
+
+// ReadsProc is whether the filter reads /proc, for uid and container. Those
+// can't match connections of the past, such as those in a Store.
+func (f *Filter) ReadsProc() bool {
+	return f.root.proc
+}
+
+// withFiles gives the filter with uid and container reading files.
+func (f *Filter) withFiles(files procFiles) *Filter {
+	g := *f
+	g.files = files
+	return &g
+}
+
+// Iter gives the connections of it which match. The owner and container of
+// every process are read once per Iter().
+func (f *Filter) Iter(it ConnIter) ConnIter {
+	return &filterIter{ConnIter: it, f: f, pc: newProcCache(f.files)}
+}
+
+type filterIter struct {
+	ConnIter
+	f  *Filter
+	pc *procCache
+}
+
+func (i *filterIter) Close() error {
+	return CloseIter(i.ConnIter)
+}
+
+func (i *filterIter) Next() *Connection {
+	for c := i.ConnIter.Next(); c != nil; c = i.ConnIter.Next() {
+		if i.f.root.match(c, i.pc) {
+			return c
+		}
+	}
+	return nil
+}
+
+// stateBackend is a Backend which can select on states itself.
+type stateBackend interface {
+	stateConnections(processes bool, states uint32) (ConnIter, error)
+}
+
+// procBackend is a Backend which reads processes from a proc tree.
+type procBackend interface {
+	procFiles() (procFiles, bool)
+}
+
+// procFiles gives the proc tree of the backend, or errNoProc.
+func (s *Scanner) procFiles() (procFiles, error) {
+	if pb, ok := s.backend().(procBackend); ok {
+		if files, ok := pb.procFiles(); ok {
+			return files, nil
+		}
+	}
+	return nil, errNoProc
+}
+
+// Select gives the connections, in any state, which match f. processes is as
+// with Connections(), but processes are also looked up when f uses them. It
+// only asks the backend for established connections if f can't match
+// anything else, and the netlink backend gets the states f can match.
+func (s *Scanner) Select(f *Filter, processes bool) (ConnIter, error) {
+	var (
+		b      = s.backend()
+		states = f.root.states
+		it     ConnIter
+		err    error
+	)
+	processes = processes || f.Processes()
+	if f.ReadsProc() {
+		files, err := s.procFiles()
+		if err != nil {
+			return nil, err
+		}
+		f = f.withFiles(files)
+	}
+	if sb, ok := b.(stateBackend); ok {
+		it, err = sb.stateConnections(processes, states)
+	} else {
+		it, err = b.Connections(processes, states&^(1<<StateEstablished) != 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.Iter(it), nil
+}
+
+// Select gives the connections which match f. See Scanner.Select().
+func Select(f *Filter, processes bool) (ConnIter, error) {
+	return (&Scanner{}).Select(f, processes)
+}
+
+type filterToken struct {
+	s      string
+	offset int
+}
+
+// tokenize splits on spaces, and makes parentheses separate tokens.
+func tokenize(expr string) []filterToken {
+	var (
+		toks  []filterToken
+		start = -1
+	)
+	for i := 0; i <= len(expr); i++ {
+		var c byte = ' '
+		if i < len(expr) {
+			c = expr[i]
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r', '(', ')':
+			if start >= 0 {
+				toks = append(toks, filterToken{s: expr[start:i], offset: start})
+				start = -1
+			}
+			if c == '(' || c == ')' {
+				toks = append(toks, filterToken{s: string(c), offset: i})
+			}
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	return toks
+}
+
+type filterParser struct {
+	toks []filterToken
+	end  int
+}
+
+func (p *filterParser) peek() (filterToken, bool) {
+	if len(p.toks) == 0 {
+		return filterToken{}, false
+	}
+	return p.toks[0], true
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if len(p.toks) == 0 {
+		return filterToken{}, &FilterError{Offset: p.end, Msg: "unexpected end of expression"}
+	}
+	t := p.toks[0]
+	p.toks = p.toks[1:]
+	return t, nil
+}
+
+func (p *filterParser) or() (filterNode, error) {
+	n, err := p.and()
+	if err != nil {
+		return n, err
+	}
+	for {
+		if t, ok := p.peek(); !ok || t.s != "or" {
+			return n, nil
+		}
+		p.next()
+		m, err := p.and()
+		if err != nil {
+			return m, err
+		}
+		a, b := n.match, m.match
+		n = filterNode{
+			match:  func(c *Connection, pc *procCache) bool { return a(c, pc) || b(c, pc) },
+			procs:  n.procs || m.procs,
+			proc:   n.proc || m.proc,
+			states: n.states | m.states,
+		}
+	}
+}
+
+func (p *filterParser) and() (filterNode, error) {
+	n, err := p.not()
+	if err != nil {
+		return n, err
+	}
+	for {
+		if t, ok := p.peek(); !ok || t.s != "and" {
+			return n, nil
+		}
+		p.next()
+		m, err := p.not()
+		if err != nil {
+			return m, err
+		}
+		a, b := n.match, m.match
+		n = filterNode{
+			match:  func(c *Connection, pc *procCache) bool { return a(c, pc) && b(c, pc) },
+			procs:  n.procs || m.procs,
+			proc:   n.proc || m.proc,
+			states: n.states & m.states,
+		}
+	}
+}
+
+func (p *filterParser) not() (filterNode, error) {
+	t, err := p.next()
+	if err != nil {
+		return filterNode{}, err
+	}
+	switch t.s {
+	case "not":
+		n, err := p.not()
+		if err != nil {
+			return n, err
+		}
+		a := n.match
+		return filterNode{
+			match:  func(c *Connection, pc *procCache) bool { return !a(c, pc) },
+			procs:  n.procs,
+			proc:   n.proc,
+			states: allStates,
+		}, nil
+	case "(":
+		n, err := p.or()
+		if err != nil {
+			return n, err
+		}
+		c, err := p.next()
+		if err != nil {
+			return n, err
+		}
+		if c.s != ")" {
+			return n, &FilterError{Offset: c.offset, Msg: fmt.Sprintf("unexpected %q, expected \")\"", c.s)}
+		}
+		return n, nil
+	}
+	return p.primitive(t)
+}
+
+func (p *filterParser) primitive(t filterToken) (filterNode, error) {
+	n := filterNode{states: allStates}
+	switch t.s {
+	case "listening":
+		n.match = func(c *Connection, pc *procCache) bool { return c.State == StateListen }
+		n.states = 1 << StateListen
+		return n, nil
+	case "inbound":
+		n.match = func(c *Connection, pc *procCache) bool { return connected(c) && !outbound(c) }
+		n.states &^= 1 << StateListen
+		return n, nil
+	case "outbound":
+		n.match = func(c *Connection, pc *procCache) bool { return connected(c) && outbound(c) }
+		n.states &^= 1 << StateListen
+		return n, nil
+	case "tcp", "udp":
+		n.match = func(c *Connection, pc *procCache) bool { return c.Transport == t.s }
+		return n, nil
+	}
+
+	switch t.s {
+	case "port", "lport", "rport", "net", "lnet", "rnet", "state", "netns",
+		"process", "pid", "uid", "container":
+	default:
+		return n, &FilterError{Offset: t.offset, Msg: fmt.Sprintf("unknown primitive %q", t.s)}
+	}
+	arg, err := p.next()
+	if err != nil {
+		return n, err
+	}
+	invalid := func(what string) (filterNode, error) {
+		return n, &FilterError{Offset: arg.offset, Msg: fmt.Sprintf("invalid %s %q", what, arg.s)}
+	}
+	switch t.s {
+	case "port", "lport", "rport":
+		lo, hi, ok := parsePortRange(arg.s)
+		if !ok {
+			return invalid("port")
+		}
+		in := func(p uint16) bool { return p >= lo && p <= hi }
+		switch t.s {
+		case "port":
+			n.match = func(c *Connection, pc *procCache) bool { return in(c.LocalPort) || in(c.RemotePort) }
+		case "lport":
+			n.match = func(c *Connection, pc *procCache) bool { return in(c.LocalPort) }
+		case "rport":
+			n.match = func(c *Connection, pc *procCache) bool { return in(c.RemotePort) }
+		}
+	case "net", "lnet", "rnet":
+		prefix, ok := parseNet(arg.s)
+		if !ok {
+			return invalid("network")
+		}
+		in := func(ap netip.AddrPort) bool { return prefix.Contains(ap.Addr().WithZone("")) }
+		switch t.s {
+		case "net":
+			n.match = func(c *Connection, pc *procCache) bool { return in(c.Local()) || in(c.Remote()) }
+		case "lnet":
+			n.match = func(c *Connection, pc *procCache) bool { return in(c.Local()) }
+		case "rnet":
+			n.match = func(c *Connection, pc *procCache) bool { return in(c.Remote()) }
+		}
+	case "state":
+		s, ok := parseStateName(arg.s)
+		if !ok {
+			return invalid("state")
+		}
+		n.match = func(c *Connection, pc *procCache) bool { return c.State == s }
+		n.states = 1 << s
+	case "netns":
+		ns, err := strconv.ParseUint(arg.s, 10, 64)
+		if err != nil {
+			return invalid("namespace")
+		}
+		n.match = func(c *Connection, pc *procCache) bool { return c.NetNS == ns }
+	case "process":
+		if _, err := path.Match(arg.s, ""); err != nil {
+			return invalid("pattern")
+		}
+		n.match = func(c *Connection, pc *procCache) bool {
+			ok, _ := path.Match(arg.s, c.Name)
+			return c.PID != 0 && ok
+		}
+		n.procs = true
+	case "pid":
+		pid, err := strconv.ParseUint(arg.s, 10, 0)
+		if err != nil || pid == 0 {
+			return invalid("pid")
+		}
+		n.match = func(c *Connection, pc *procCache) bool { return c.PID == uint(pid) }
+		n.procs = true
+	case "uid":
+		uid, err := strconv.ParseUint(arg.s, 10, 32)
+		if err != nil {
+			return invalid("uid")
+		}
+		n.match = func(c *Connection, pc *procCache) bool {
+			if c.PID == 0 {
+				return false
+			}
+			u, ok := pc.uid(c.PID)
+			return ok && u == uint32(uid)
+		}
+		n.procs, n.proc = true, true
+	case "container":
+		id := strings.ToLower(arg.s)
+		n.match = func(c *Connection, pc *procCache) bool {
+			return c.PID != 0 && strings.HasPrefix(pc.container(c.PID), id)
+		}
+		n.procs, n.proc = true, true
+	}
+	return n, nil
+}
+
+// connected is whether there is a remote end.
+func connected(c *Connection) bool {
+	return c.State != StateListen && c.RemotePort != 0
+}
+
+func outbound(c *Connection) bool {
+	l, r := c.LocalPort >= ephemeralPorts, c.RemotePort >= ephemeralPorts
+	if l != r {
+		return l
+	}
+	return c.RemotePort < c.LocalPort
+}
+
+// parsePortRange parses "80" or "8000-8100".
+func parsePortRange(s string) (uint16, uint16, bool) {
+	from, to, isRange := strings.Cut(s, "-")
+	lo, err := strconv.ParseUint(from, 10, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !isRange {
+		return uint16(lo), uint16(lo), true
+	}
+	hi, err := strconv.ParseUint(to, 10, 16)
+	if err != nil || hi < lo {
+		return 0, 0, false
+	}
+	return uint16(lo), uint16(hi), true
+}
+
+// parseNet parses a CIDR, or a single address.
+func parseNet(s string) (netip.Prefix, bool) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), true
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil || a.Zone() != "" {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(a, a.BitLen()), true
+}
+
+// parseStateName understands the Linux names, in any case, and those of ss.
+func parseStateName(s string) (State, bool) {
+	name := strings.ToUpper(s)
+	var st State
+	if err := st.UnmarshalText([]byte(name)); err == nil {
+		return st, true
+	}
+	st, ok := ssStates[name]
+	return st, ok
+}
+
+// parseStatusUID finds the effective UID in a /proc/<pid>/status file.
+func parseStatusUID(b []byte) (uint32, bool) {
+	for _, l := range bytes.Split(b, []byte("\n")) {
+		v, ok := bytes.CutPrefix(l, []byte("Uid:"))
+		if !ok {
+			continue
+		}
+		// real, effective, saved, and filesystem
+		f := bytes.Fields(v)
+		if len(f) < 2 {
+			return 0, false
+		}
+		uid, err := strconv.ParseUint(string(f[1]), 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		return uint32(uid), true
+	}
+	return 0, false
+}
+
+// parseCgroupContainer finds the container ID in a /proc/<pid>/cgroup file,
+// which is the 64 hex digits in paths such as
+// "/system.slice/docker-<id>.scope" or "/kubepods/.../<id>".
+func parseCgroupContainer(b []byte) string {
+	var id string
+	for _, f := range bytes.FieldsFunc(b, func(r rune) bool {
+		return !('0' <= r && r <= '9' || 'a' <= r && r <= 'f')
+	}) {
+		if len(f) == 64 {
+			id = string(f)
+		}
+	}
+	return id
+}
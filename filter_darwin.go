@@ -0,0 +1,11 @@
+package procspy
+
+// procUID is not supported on Darwin.
+func procUID(files procFiles, pid uint) (uint32, bool) {
+	return 0, false
+}
+
+// procContainer is not supported on Darwin.
+func procContainer(files procFiles, pid uint) string {
+	return ""
+}
@@ -0,0 +1,48 @@
+package procspy
+
+import (
+	"io"
+	"strconv"
+	"syscall"
+)
+
+// procUID gives the owner of a process. That's the owner of /proc/<pid>, or
+// in a proc tree which isn't /proc, the effective UID in its status file.
+func procUID(files procFiles, pid uint) (uint32, bool) {
+	dir := strconv.FormatUint(uint64(pid), 10)
+	if _, ok := files.(osFiles); ok {
+		fi, err := files.stat(dir)
+		if err != nil {
+			return 0, false
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return 0, false
+		}
+		return st.Uid, true
+	}
+	b, err := readProcFile(files, dir+"/status")
+	if err != nil {
+		return 0, false
+	}
+	return parseStatusUID(b)
+}
+
+// procContainer gives the container ID of a process, or "".
+func procContainer(files procFiles, pid uint) string {
+	b, err := readProcFile(files, strconv.FormatUint(uint64(pid), 10)+"/cgroup")
+	if err != nil {
+		return ""
+	}
+	return parseCgroupContainer(b)
+}
+
+// readProcFile reads a small file, such as a status or cgroup file.
+func readProcFile(files procFiles, name string) ([]byte, error) {
+	f, err := files.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, 64*1024))
+}
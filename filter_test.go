@@ -0,0 +1,185 @@
+package procspy
+
+import (
+	"reflect"
+	"testing"
+)
+
+// argsBackend remembers how it was called.
+type argsBackend struct {
+	Fixtures
+	processes, all bool
+}
+
+func (b *argsBackend) Connections(processes, all bool) (ConnIter, error) {
+	b.processes, b.all = processes, all
+	return b.Fixtures.Connections(processes, all)
+}
+
+func TestFilter(t *testing.T) {
+	var (
+		nginx = Proc{PID: 42, Name: "nginx"}
+		curl  = Proc{PID: 43, Name: "curl"}
+		cs    = []Connection{
+			0: testConnection(StateListen, "0.0.0.0:443", "0.0.0.0:0", 1, nginx),
+			1: testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.5:50123", 1, nginx),
+			2: testConnection(StateEstablished, "10.0.0.1:40001", "93.184.216.34:80", 1, curl),
+			3: testConnection(StateTimeWait, "[fe80::1]:8080", "[fe80::2]:51000", 2, Proc{}),
+			4: testConnection(StateEstablished, "127.0.0.1:5432", "127.0.0.1:5433", 1, Proc{}),
+		}
+	)
+	for _, c := range []struct {
+		expr  string
+		want  []int
+		procs bool
+	}{
+		{expr: "", want: []int{0, 1, 2, 3, 4}},
+		{expr: "port 443", want: []int{0, 1}},
+		{expr: "lport 8000-9000", want: []int{3}},
+		{expr: "rport 50000-60000", want: []int{1, 3}},
+		{expr: "net 192.168.0.0/16", want: []int{1}},
+		{expr: "lnet fe80::/10", want: []int{3}},
+		{expr: "rnet 93.184.216.34", want: []int{2}},
+		{expr: "state time-wait or state TIME_WAIT", want: []int{3}},
+		{expr: "listening", want: []int{0}},
+		{expr: "inbound", want: []int{1, 3, 4}},
+		{expr: "outbound", want: []int{2}},
+		{expr: "tcp and not udp", want: []int{0, 1, 2, 3, 4}},
+		{expr: "netns 2", want: []int{3}},
+		{expr: "port 443 and process nginx", want: []int{0, 1}, procs: true},
+		{expr: "process ng* and not listening", want: []int{1}, procs: true},
+		{expr: "pid 43 or (state established and net 127.0.0.0/8)", want: []int{2, 4}, procs: true},
+		{expr: "not (port 443 or port 80)", want: []int{3, 4}},
+	} {
+		f, err := ParseFilter(c.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", c.expr, err)
+		}
+		var have []int
+		for i := range cs {
+			if f.Match(&cs[i]) {
+				have = append(have, i)
+			}
+		}
+		if !reflect.DeepEqual(have, c.want) {
+			t.Errorf("%q: have %v, want %v", c.expr, have, c.want)
+		}
+		if have, want := f.Processes(), c.procs; have != want {
+			t.Errorf("%q: have %t, want %t", c.expr, have, want)
+		}
+	}
+
+	for expr, offset := range map[string]int{
+		"port":               4,
+		"port 70000":         5,
+		"port 90-80":         5,
+		"net 10.0.0.0/33":    4,
+		"state nosuch":       6,
+		"pid x":              4,
+		"process [":          8,
+		"port 80 or":         10,
+		"(port 80":           8,
+		"port 80 port 443":   8,
+		"nosuch":             0,
+		"port 80 and (tcp))": 17,
+		"not not not":        11,
+	} {
+		_, err := ParseFilter(expr)
+		fe, ok := err.(*FilterError)
+		if !ok {
+			t.Errorf("%q: have %v, want a FilterError", expr, err)
+			continue
+		}
+		if fe.Offset != offset {
+			t.Errorf("%q: have offset %d (%s), want %d", expr, fe.Offset, fe, offset)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	b := &argsBackend{
+		Fixtures: Fixtures{
+			testConnection(StateListen, "0.0.0.0:443", "0.0.0.0:0", 0, Proc{}),
+			testConnection(StateEstablished, "10.0.0.1:443", "10.0.0.2:50000", 0, Proc{}),
+		},
+	}
+	s := NewScanner(b)
+	for _, c := range []struct {
+		expr           string
+		processes, all bool
+		n              int
+	}{
+		{expr: "port 443", all: true, n: 2},
+		{expr: "port 443 and state established", n: 1},
+		{expr: "state established or state listen", all: true, n: 2},
+		{expr: "not state established", all: true, n: 1},
+		{expr: "process nginx", processes: true, all: true, n: 0},
+	} {
+		f, err := ParseFilter(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs, err := s.Select(f, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for c := cs.Next(); c != nil; c = cs.Next() {
+			n++
+		}
+		if n != c.n {
+			t.Errorf("%q: have %d connections, want %d", c.expr, n, c.n)
+		}
+		if b.processes != c.processes || b.all != c.all {
+			t.Errorf("%q: have processes %t all %t, want %t %t", c.expr, b.processes, b.all, c.processes, c.all)
+		}
+	}
+}
+
+func TestSelectProc(t *testing.T) {
+	s := NewScanner(Fixtures{
+		testConnection(StateEstablished, "10.0.0.1:443", "10.0.0.2:50000", 0, Proc{PID: 42, Name: "nginx"}),
+	})
+	f, err := ParseFilter("port 443 and uid 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.ReadsProc() {
+		t.Errorf("no ReadsProc()")
+	}
+	if _, err := s.Select(f, true); err != errNoProc {
+		t.Errorf("have %v, want %v", err, errNoProc)
+	}
+}
+
+func TestParseStatusUID(t *testing.T) {
+	for in, want := range map[string]int{
+		"Name:\tnginx\nUid:\t0\t33\t33\t33\nGid:\t0\t33\t33\t33\n": 33,
+		"Uid:\t1000\t1000\t1000\t1000":                             1000,
+		"Name:\tnginx\n":                                           -1,
+		"Uid:\t0\n":                                                -1,
+	} {
+		uid, ok := parseStatusUID([]byte(in))
+		have := int(uid)
+		if !ok {
+			have = -1
+		}
+		if have != want {
+			t.Errorf("%q: have %d, want %d", in, have, want)
+		}
+	}
+}
+
+func TestParseCgroupContainer(t *testing.T) {
+	const id = "3f4e1c2b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+	for in, want := range map[string]string{
+		"0::/system.slice/docker-" + id + ".scope\n":                             id,
+		"12:cpu,cpuacct:/docker/" + id + "\n1:name=systemd:/docker/" + id:        id,
+		"0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + id + ".scope": id,
+		"0::/user.slice/user-1000.slice/session-2.scope\n":                       "",
+	} {
+		if have := parseCgroupContainer([]byte(in)); have != want {
+			t.Errorf("%q: have %q, want %q", in, have, want)
+		}
+	}
+}
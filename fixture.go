@@ -17,10 +17,12 @@ func (f *fixedConnIter) Next() *Connection {
 	return &car
 }
 
-// SetFixtures is used in test scenarios to have known output.
+func (f *fixedConnIter) Err() error {
+	return nil
+}
+
+// SetFixtures is used in test scenarios to have known output. It's the same
+// as SetBackend(Fixtures(c)).
 func SetFixtures(c []Connection) {
-	cbConnections = func(bool) (ConnIter, error) {
-		f := fixedConnIter(c)
-		return &f, nil
-	}
+	SetBackend(Fixtures(c))
 }
@@ -0,0 +1,54 @@
+package procspy
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// The seed corpus is in testdata/fuzz/. Run with, for example:
+//
+//   go test -fuzz FuzzProcNet
+//
+
+func FuzzProcNet(f *testing.F) {
+	f.Add(fixture)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var (
+			p   = NewProcNet(b, tcpEstablished)
+			pr  = NewProcNetReader(bytes.NewReader(b), tcpEstablished)
+			cs  []Connection
+			crs []Connection
+		)
+		for c := p.Next(); c != nil; c = p.Next() {
+			cs = append(cs, c.clone())
+		}
+		for c := pr.Next(); c != nil; c = pr.Next() {
+			crs = append(crs, c.clone())
+		}
+		if len(b) >= procNetReaderSize {
+			// The reader has a limit on the line length.
+			return
+		}
+		if !reflect.DeepEqual(cs, crs) {
+			t.Errorf("ProcNet and ProcNetReader differ:\n%+v\n%+v", cs, crs)
+		}
+		if have, want := pr.Err(), p.Err(); !reflect.DeepEqual(have, want) {
+			t.Errorf("ProcNet and ProcNetReader differ: %v, %v", have, want)
+		}
+	})
+}
+
+func FuzzParseLSOF(f *testing.F) {
+	f.Add("p13100\ncmpd\nn[::1]:6600->[::1]:50992\n")
+	f.Fuzz(func(t *testing.T, s string) {
+		parseLSOF(s)
+	})
+}
+
+func FuzzParseDarwinNetstat(f *testing.F) {
+	f.Add("Active Internet connections\nProto Recv-Q Send-Q  Local Address          Foreign Address        (state)\ntcp4       0      0  10.0.1.6.58287         1.2.3.4.443      		ESTABLISHED\n")
+	f.Fuzz(func(t *testing.T, s string) {
+		parseDarwinNetstat(s, true)
+	})
+}
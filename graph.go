@@ -0,0 +1,232 @@
+package procspy
+
+// Dependency graphs of processes and hosts.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Graph is connections collapsed into edges between processes, containers,
+// and remote hosts. The port of an edge is the port of the server side, so
+// all connections from ephemeral ports to a service are a single edge. When
+// both ends of a connection are on this host (in the same network namespace)
+// the edge goes from process to process.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"` // sorted on ID
+	Edges []GraphEdge `json:"edges"` // sorted on From, To, and Port
+}
+
+// GraphNode is a process, a container, or a remote host.
+type GraphNode struct {
+	ID    string `json:"id"`   // such as "process:nginx" or "host:10.0.0.2"
+	Kind  string `json:"kind"` // "process", "container", or "host"
+	Label string `json:"label"`
+}
+
+// GraphEdge is all connections from a client to a server port.
+type GraphEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Port  uint16 `json:"port"`
+	Count int    `json:"count"`
+}
+
+// GraphOptions are the options of NewGraph().
+type GraphOptions struct {
+	// Containers makes processes in a container a single node. See Filter
+	// for how containers are found.
+	Containers bool
+	// HostBits4 and HostBits6 collapse remote hosts to networks of this
+	// size. 0 keeps the addresses.
+	HostBits4, HostBits6 int
+}
+
+// NewGraph builds a graph from connections, which need their processes.
+// Connections without a remote end, such as listening sockets, are skipped.
+func NewGraph(it ConnIter, opts GraphOptions) (*Graph, error) {
+	return (&Scanner{}).NewGraph(it, opts)
+}
+
+// NewGraph is like the package level NewGraph(), with containers read from
+// the proc tree of the backend.
+func (s *Scanner) NewGraph(it ConnIter, opts GraphOptions) (*Graph, error) {
+	var pc *procCache
+	if opts.Containers {
+		files, err := s.procFiles()
+		if err != nil {
+			return nil, err
+		}
+		pc = newProcCache(files)
+	}
+	snap, err := NewSnapshot(it, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	b := newGraphBuilder()
+	local := func(c *Connection) string {
+		n := GraphNode{Kind: "process", Label: c.Name}
+		if c.PID == 0 {
+			n.Label = "unknown"
+		}
+		if opts.Containers && c.PID != 0 {
+			if id := pc.container(c.PID); id != "" {
+				n = GraphNode{Kind: "container", Label: shortID(id)}
+			}
+		}
+		n.ID = n.Kind + ":" + n.Label
+		return b.node(n)
+	}
+	host := func(c *Connection) string {
+		a := c.Remote().Addr().WithZone("")
+		label := a.String()
+		bits := opts.HostBits4
+		if a.Is6() {
+			bits = opts.HostBits6
+		}
+		if bits > 0 && bits < a.BitLen() {
+			p, _ := a.Prefix(bits)
+			label = p.String()
+		}
+		return b.node(GraphNode{ID: "host:" + label, Kind: "host", Label: label})
+	}
+
+	for i := range snap.Connections {
+		c := &snap.Connections[i]
+		if !connected(c) {
+			continue
+		}
+		// The other end, if it's here as well.
+		peer, here := snap.Get(Key{
+			Transport: c.Transport,
+			NetNS:     c.NetNS,
+			Local:     c.Remote(),
+			Remote:    c.Local(),
+		})
+		var e GraphEdge
+		switch {
+		case outbound(c) && here:
+			e = GraphEdge{From: local(c), To: local(&peer), Port: c.RemotePort}
+		case outbound(c):
+			e = GraphEdge{From: local(c), To: host(c), Port: c.RemotePort}
+		case here:
+			// The peer's outbound connection is the same edge.
+			continue
+		default:
+			e = GraphEdge{From: host(c), To: local(c), Port: c.LocalPort}
+		}
+		b.edges[e]++
+	}
+	return b.graph(), nil
+}
+
+// graphBuilder collects nodes and edges.
+type graphBuilder struct {
+	nodes map[string]GraphNode
+	edges map[GraphEdge]int // without Count
+}
+
+func newGraphBuilder() *graphBuilder {
+	return &graphBuilder{
+		nodes: map[string]GraphNode{},
+		edges: map[GraphEdge]int{},
+	}
+}
+
+// node adds a node, and gives its ID.
+func (b *graphBuilder) node(n GraphNode) string {
+	b.nodes[n.ID] = n
+	return n.ID
+}
+
+func (b *graphBuilder) graph() *Graph {
+	nodes, edges := b.nodes, b.edges
+	g := &Graph{
+		Nodes: make([]GraphNode, 0, len(nodes)),
+		Edges: make([]GraphEdge, 0, len(edges)),
+	}
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	for e, n := range edges {
+		e.Count = n
+		g.Edges = append(g.Edges, e)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Port < b.Port
+	})
+	return g
+}
+
+// shortID shortens a container ID, like docker does.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// edgeLabel is "443", or "443 (12)" for more connections.
+func edgeLabel(e GraphEdge) string {
+	if e.Count == 1 {
+		return fmt.Sprint(e.Port)
+	}
+	return fmt.Sprintf("%d (%d)", e.Port, e.Count)
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// WriteDOT writes the graph in the Graphviz DOT language.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph procspy {\n\trankdir=LR;\n")
+	for _, n := range g.Nodes {
+		shape := "box"
+		if n.Kind == "host" {
+			shape = "ellipse"
+		}
+		fmt.Fprintf(&b, "\t\"%s\" [label=\"%s\", shape=%s];\n", dotEscaper.Replace(n.ID), dotEscaper.Replace(n.Label), shape)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t\"%s\" -> \"%s\" [label=\"%s\"];\n", dotEscaper.Replace(e.From), dotEscaper.Replace(e.To), edgeLabel(e))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;")
+
+// WriteMermaid writes the graph as a Mermaid flowchart.
+func (g *Graph) WriteMermaid(w io.Writer) error {
+	var (
+		b   strings.Builder
+		ids = make(map[string]string, len(g.Nodes))
+	)
+	b.WriteString("flowchart LR\n")
+	for i, n := range g.Nodes {
+		id := fmt.Sprintf("n%d", i)
+		ids[n.ID] = id
+		start, end := "[", "]"
+		if n.Kind == "host" {
+			start, end = "([", "])"
+		}
+		fmt.Fprintf(&b, "\t%s%s\"%s\"%s\n", id, start, mermaidEscaper.Replace(n.Label), end)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%s -->|\"%s\"| %s\n", ids[e.From], edgeLabel(e), ids[e.To])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
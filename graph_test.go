@@ -0,0 +1,105 @@
+package procspy
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestGraph(t *testing.T) {
+	var (
+		nginx = Proc{PID: 42, Name: "nginx"}
+		app   = Proc{PID: 43, Name: "app"}
+		cs    = fixedConnIter{
+			testConnection(StateListen, "0.0.0.0:443", "0.0.0.0:0", 1, nginx),
+			// Clients to nginx.
+			testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.5:50123", 1, nginx),
+			testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.5:50124", 1, nginx),
+			testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.6:50125", 1, nginx),
+			// nginx to app, both here.
+			testConnection(StateEstablished, "127.0.0.1:40001", "127.0.0.1:8080", 1, nginx),
+			testConnection(StateEstablished, "127.0.0.1:8080", "127.0.0.1:40001", 1, app),
+			// app to a database.
+			testConnection(StateEstablished, "10.0.0.1:40002", "10.0.0.9:5432", 1, app),
+			testConnection(StateEstablished, "10.0.0.1:40003", "10.0.0.9:5432", 1, app),
+			// Someone to app, we don't know who.
+			testConnection(StateEstablished, "[::1]:40004", "[::1]:8080", 1, Proc{}),
+		}
+	)
+	g, err := NewGraph(&cs, GraphOptions{HostBits4: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Graph{
+		Nodes: []GraphNode{
+			{ID: "host:10.0.0.0/24", Kind: "host", Label: "10.0.0.0/24"},
+			{ID: "host:192.168.1.0/24", Kind: "host", Label: "192.168.1.0/24"},
+			{ID: "host:::1", Kind: "host", Label: "::1"},
+			{ID: "process:app", Kind: "process", Label: "app"},
+			{ID: "process:nginx", Kind: "process", Label: "nginx"},
+			{ID: "process:unknown", Kind: "process", Label: "unknown"},
+		},
+		Edges: []GraphEdge{
+			{From: "host:192.168.1.0/24", To: "process:nginx", Port: 443, Count: 3},
+			{From: "process:app", To: "host:10.0.0.0/24", Port: 5432, Count: 2},
+			{From: "process:nginx", To: "process:app", Port: 8080, Count: 1},
+			{From: "process:unknown", To: "host:::1", Port: 8080, Count: 1},
+		},
+	}
+	if !reflect.DeepEqual(g, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", g, want)
+	}
+
+	var b bytes.Buffer
+	if err := g.WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := b.String(), `digraph procspy {
+	rankdir=LR;
+	"host:10.0.0.0/24" [label="10.0.0.0/24", shape=ellipse];
+	"host:192.168.1.0/24" [label="192.168.1.0/24", shape=ellipse];
+	"host:::1" [label="::1", shape=ellipse];
+	"process:app" [label="app", shape=box];
+	"process:nginx" [label="nginx", shape=box];
+	"process:unknown" [label="unknown", shape=box];
+	"host:192.168.1.0/24" -> "process:nginx" [label="443 (3)"];
+	"process:app" -> "host:10.0.0.0/24" [label="5432 (2)"];
+	"process:nginx" -> "process:app" [label="8080"];
+	"process:unknown" -> "host:::1" [label="8080"];
+}
+`; have != want {
+		t.Errorf("have\n%s\nwant\n%s", have, want)
+	}
+
+	b.Reset()
+	if err := g.WriteMermaid(&b); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := b.String(), `flowchart LR
+	n0(["10.0.0.0/24"])
+	n1(["192.168.1.0/24"])
+	n2(["::1"])
+	n3["app"]
+	n4["nginx"]
+	n5["unknown"]
+	n1 -->|"443 (3)"| n4
+	n3 -->|"5432 (2)"| n0
+	n4 -->|"8080"| n3
+	n5 -->|"8080"| n2
+`; have != want {
+		t.Errorf("have\n%s\nwant\n%s", have, want)
+	}
+
+	j, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back Graph
+	if err := json.Unmarshal(j, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&back, g) {
+		t.Errorf("have\n%+v\nwant\n%+v", back, g)
+	}
+}
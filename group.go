@@ -0,0 +1,223 @@
+package procspy
+
+// Aggregation of connections.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GroupField is something to group connections on. See ParseGroupFields().
+type GroupField struct {
+	name   string
+	v4, v6 int // prefix lengths for "raddr"
+}
+
+// groupFields are the fields and whether they need processes.
+var groupFields = map[string]bool{
+	"process":   true,
+	"pid":       true,
+	"container": true,
+	"transport": false,
+	"lport":     false,
+	"rport":     false,
+	"raddr":     false,
+	"state":     false,
+	"direction": false,
+	"netns":     false,
+}
+
+// ParseGroupFields parses a comma separated list of fields:
+//
+//	process     the process name
+//	pid         the process ID
+//	container   the container ID, see Filter
+//	transport   "tcp"
+//	lport       the local port
+//	rport       the remote port
+//	raddr[/N[/M]]
+//	            the remote address, or its network: /N for IPv4, /M for IPv6
+//	state       the TCP state
+//	direction   "listen", "inbound", or "outbound", see Filter
+//	netns       the network namespace
+//
+// Values are "" when they're unknown.
+func ParseGroupFields(s string) ([]GroupField, error) {
+	var fs []GroupField
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		f := GroupField{name: name, v4: 32, v6: 128}
+		if base, bits, ok := strings.Cut(name, "/"); ok && base == "raddr" {
+			f.name = base
+			v4, v6, hasV6 := strings.Cut(bits, "/")
+			n, err := strconv.Atoi(v4)
+			if err != nil || n < 0 || n > 32 {
+				return nil, fmt.Errorf("invalid IPv4 prefix length in %q", name)
+			}
+			f.v4 = n
+			if hasV6 {
+				n, err := strconv.Atoi(v6)
+				if err != nil || n < 0 || n > 128 {
+					return nil, fmt.Errorf("invalid IPv6 prefix length in %q", name)
+				}
+				f.v6 = n
+			}
+		}
+		if _, ok := groupFields[f.name]; !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// String gives the field as ParseGroupFields() takes it.
+func (f GroupField) String() string {
+	if f.name != "raddr" || (f.v4 == 32 && f.v6 == 128) {
+		return f.name
+	}
+	if f.v6 == 128 {
+		return fmt.Sprintf("raddr/%d", f.v4)
+	}
+	return fmt.Sprintf("raddr/%d/%d", f.v4, f.v6)
+}
+
+// Name is the name of the field, without the prefix lengths of "raddr".
+func (f GroupField) Name() string {
+	return f.name
+}
+
+// Processes is whether the field needs processes to be looked up.
+func (f GroupField) Processes() bool {
+	return groupFields[f.name]
+}
+
+func (f GroupField) value(c *Connection, pc *procCache) string {
+	switch f.name {
+	case "process":
+		return c.Name
+	case "pid":
+		if c.PID == 0 {
+			return ""
+		}
+		return strconv.FormatUint(uint64(c.PID), 10)
+	case "container":
+		if c.PID == 0 {
+			return ""
+		}
+		return pc.container(c.PID)
+	case "transport":
+		return c.Transport
+	case "lport":
+		return strconv.Itoa(int(c.LocalPort))
+	case "rport":
+		return strconv.Itoa(int(c.RemotePort))
+	case "raddr":
+		a := c.Remote().Addr().WithZone("")
+		bits := f.v4
+		if a.Is6() {
+			bits = f.v6
+		}
+		if bits == a.BitLen() {
+			return a.String()
+		}
+		p, _ := a.Prefix(bits)
+		return p.String()
+	case "state":
+		return c.State.String()
+	case "direction":
+		switch {
+		case c.State == StateListen:
+			return "listen"
+		case !connected(c):
+			return ""
+		case outbound(c):
+			return "outbound"
+		}
+		return "inbound"
+	case "netns":
+		if c.NetNS == 0 {
+			return ""
+		}
+		return strconv.FormatUint(c.NetNS, 10)
+	}
+	return ""
+}
+
+// Group is the connections with the same values for the fields they were
+// grouped on. RecvQ and SendQ are the sums of the queues, as far as they're
+// known.
+type Group struct {
+	Values []string // in the order of the fields
+	Count  int
+	RecvQ  uint64
+	SendQ  uint64
+}
+
+// GroupBy counts the connections per combination of values of the fields.
+// The biggest groups come first, groups of the same size are sorted on their
+// values. Use Select() or Connections(true) to get the processes if a field
+// needs them.
+func GroupBy(it ConnIter, fields ...GroupField) ([]Group, error) {
+	return (&Scanner{}).GroupBy(it, fields...)
+}
+
+// GroupBy is like the package level GroupBy(), with containers read from the
+// proc tree of the backend.
+func (s *Scanner) GroupBy(it ConnIter, fields ...GroupField) ([]Group, error) {
+	var (
+		groups = map[string]*Group{}
+		values = make([]string, len(fields))
+		pc     *procCache
+	)
+	for _, f := range fields {
+		if f.name == "container" {
+			files, err := s.procFiles()
+			if err != nil {
+				return nil, err
+			}
+			pc = newProcCache(files)
+			break
+		}
+	}
+	for c := it.Next(); c != nil; c = it.Next() {
+		for i, f := range fields {
+			values[i] = f.value(c, pc)
+		}
+		key := strings.Join(values, "\x00")
+		g, ok := groups[key]
+		if !ok {
+			g = &Group{Values: append([]string(nil), values...)}
+			groups[key] = g
+		}
+		g.Count++
+		g.RecvQ += uint64(c.RecvQ)
+		g.SendQ += uint64(c.SendQ)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	res := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, *g)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return lessValues(res[i].Values, res[j].Values)
+	})
+	return res, nil
+}
+
+func lessValues(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
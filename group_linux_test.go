@@ -0,0 +1,144 @@
+package procspy
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// countedFS counts the opens of files, by their base name.
+type countedFS struct {
+	fstest.MapFS
+	opens map[string]int
+}
+
+func (c *countedFS) Open(name string) (fs.File, error) {
+	if c.opens == nil {
+		c.opens = map[string]int{}
+	}
+	c.opens[path.Base(name)]++
+	return c.MapFS.Open(name)
+}
+
+func TestGroupByContainer(t *testing.T) {
+	const id = "3f4e1c2b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+	var (
+		nginx = Proc{PID: 42, Name: "nginx"}
+		fsys  = &countedFS{MapFS: fstest.MapFS{
+			"42/cgroup": &fstest.MapFile{Data: []byte("0::/system.slice/docker-" + id + ".scope\n")},
+		}}
+		s = NewScanner(ProcFS{FS: fsys})
+	)
+	// Nothing of this host is used.
+	defer SetProcRoot(procRoot)
+	SetProcRoot(t.TempDir())
+	fields, err := ParseGroupFields("container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := fixedConnIter{
+		testConnection(StateListen, "0.0.0.0:443", "0.0.0.0:0", 1, nginx),
+		testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.5:50123", 1, nginx),
+		testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.6:50124", 1, nginx),
+	}
+	have, err := s.GroupBy(&cs, fields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Group{{Values: []string{id}, Count: 3}}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+	if have, want := fsys.opens["cgroup"], 1; have != want {
+		t.Errorf("have %d reads, want %d", have, want)
+	}
+
+	fsys.opens = nil
+	cs = fixedConnIter{
+		testConnection(StateEstablished, "10.0.0.1:40001", "10.0.0.2:80", 1, nginx),
+		testConnection(StateEstablished, "10.0.0.1:40002", "10.0.0.2:80", 1, nginx),
+	}
+	if _, err := s.NewGraph(&cs, GraphOptions{Containers: true}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fsys.opens["cgroup"], 1; have != want {
+		t.Errorf("have %d reads, want %d", have, want)
+	}
+
+	cs = fixedConnIter{}
+	if _, err := NewScanner(Fixtures{}).GroupBy(&cs, fields...); err != errNoProc {
+		t.Errorf("have %v, want %v", err, errNoProc)
+	}
+}
+
+func TestGroupByContainerCapture(t *testing.T) {
+	const (
+		captured = "3f4e1c2b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+		live     = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	)
+	tree := func(id string) string {
+		root := t.TempDir()
+		makeProcTree(t, root, []fakeProc{
+			{pid: 42, name: "nginx", start: 100, netns: "a", sockets: 2},
+		})
+		cgroup := []byte("0::/system.slice/docker-" + id + ".scope\n")
+		if err := os.WriteFile(root+"/42/cgroup", cgroup, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+	defer SetProcRoot(procRoot)
+	SetProcRoot(tree(captured))
+	var buf bytes.Buffer
+	if err := Record(&buf); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "capture.zip")
+	if err := os.WriteFile(name, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The same PID is in another container now.
+	SetProcRoot(tree(live))
+
+	c, err := OpenCapture(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s := NewScanner(c)
+	f, err := ParseFilter("pid 42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := s.Select(f, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := ParseGroupFields("process,container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := s.GroupBy(it, fields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Group{{Values: []string{"nginx", captured}, Count: 2}}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+
+	it, err = s.Select(f, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := s.NewGraph(it, GraphOptions{Containers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := g.Nodes[0].ID, "container:"+shortID(captured); have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
@@ -0,0 +1,97 @@
+package procspy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupBy(t *testing.T) {
+	var (
+		nginx = Proc{PID: 42, Name: "nginx"}
+		curl  = Proc{PID: 43, Name: "curl"}
+		cs    = fixedConnIter{
+			testConnection(StateListen, "0.0.0.0:443", "0.0.0.0:0", 1, nginx),
+			testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.5:50123", 1, nginx),
+			testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.6:50124", 1, nginx),
+			testConnection(StateTimeWait, "10.0.0.1:443", "192.168.2.7:50125", 1, Proc{}),
+			testConnection(StateEstablished, "10.0.0.1:40001", "93.184.216.34:80", 1, curl),
+			testConnection(StateEstablished, "[2001:db8::1]:40002", "[2001:db8:1::2]:443", 1, curl),
+		}
+	)
+	cs[1].RecvQ, cs[1].SendQ = 10, 100
+	cs[2].RecvQ, cs[2].SendQ = 5, 0
+
+	for _, c := range []struct {
+		fields string
+		want   []Group
+	}{
+		{
+			fields: "process,state",
+			want: []Group{
+				{Values: []string{"curl", "ESTABLISHED"}, Count: 2},
+				{Values: []string{"nginx", "ESTABLISHED"}, Count: 2, RecvQ: 15, SendQ: 100},
+				{Values: []string{"", "TIME_WAIT"}, Count: 1},
+				{Values: []string{"nginx", "LISTEN"}, Count: 1},
+			},
+		},
+		{
+			fields: "raddr/16/32,direction",
+			want: []Group{
+				{Values: []string{"192.168.0.0/16", "inbound"}, Count: 3, RecvQ: 15, SendQ: 100},
+				{Values: []string{"0.0.0.0/16", "listen"}, Count: 1},
+				{Values: []string{"2001:db8::/32", "outbound"}, Count: 1},
+				{Values: []string{"93.184.0.0/16", "outbound"}, Count: 1},
+			},
+		},
+		{
+			fields: "transport,rport",
+			want: []Group{
+				{Values: []string{"tcp", "0"}, Count: 1},
+				{Values: []string{"tcp", "443"}, Count: 1},
+				{Values: []string{"tcp", "50123"}, Count: 1, RecvQ: 10, SendQ: 100},
+				{Values: []string{"tcp", "50124"}, Count: 1, RecvQ: 5},
+				{Values: []string{"tcp", "50125"}, Count: 1},
+				{Values: []string{"tcp", "80"}, Count: 1},
+			},
+		},
+		{
+			fields: "lport, pid",
+			want: []Group{
+				{Values: []string{"443", "42"}, Count: 3, RecvQ: 15, SendQ: 100},
+				{Values: []string{"40001", "43"}, Count: 1},
+				{Values: []string{"40002", "43"}, Count: 1},
+				{Values: []string{"443", ""}, Count: 1},
+			},
+		},
+	} {
+		fields, err := ParseGroupFields(c.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := cs
+		have, err := GroupBy(&it, fields...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have, c.want) {
+			t.Errorf("%s: have\n%+v\nwant\n%+v", c.fields, have, c.want)
+		}
+	}
+
+	for _, s := range []string{"", "nosuch", "raddr/33", "raddr/24/129", "lport/8"} {
+		if _, err := ParseGroupFields(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+	fields, err := ParseGroupFields("raddr/24,raddr/24/64,raddr,netns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.String())
+	}
+	if have, want := names, []string{"raddr/24", "raddr/24/64", "raddr", "netns"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
@@ -0,0 +1,125 @@
+// identd is an RFC 1413 ident server. It answers which user owns a TCP
+// connection between this host and the client asking.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alicebob/procspy"
+)
+
+var (
+	listen  = flag.String("listen", ":113", "address to listen on")
+	timeout = flag.Duration("timeout", 30*time.Second, "idle timeout of a client")
+	verbose = flag.Bool("v", false, "log every query")
+)
+
+func main() {
+	flag.Parse()
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go serve(c)
+	}
+}
+
+func serve(c net.Conn) {
+	defer c.Close()
+	var (
+		local  = c.LocalAddr().(*net.TCPAddr).AddrPort().Addr()
+		remote = c.RemoteAddr().(*net.TCPAddr).AddrPort().Addr()
+		r      = bufio.NewReader(c)
+	)
+	for {
+		c.SetDeadline(time.Now().Add(*timeout))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		reply := answer(line, local, remote, procspy.ConnOwner, username)
+		if *verbose {
+			log.Printf("%s: %q -> %q", remote, strings.TrimSpace(line), reply)
+		}
+		if _, err := fmt.Fprintf(c, "%s\r\n", reply); err != nil {
+			return
+		}
+	}
+}
+
+// answer replies to a single query. The server port is ours, the client port
+// is on the host asking.
+func answer(
+	query string,
+	local, remote netip.Addr,
+	owner func(local, remote netip.AddrPort) (procspy.Connection, error),
+	username func(pid uint) (string, bool),
+) string {
+	serverPort, clientPort, ok := parseQuery(query)
+	if !ok {
+		return fmt.Sprintf("%s : ERROR : INVALID-PORT", strings.TrimSpace(query))
+	}
+	ports := fmt.Sprintf("%d , %d", serverPort, clientPort)
+	c, err := owner(
+		netip.AddrPortFrom(local, serverPort),
+		netip.AddrPortFrom(remote, clientPort),
+	)
+	if err != nil || c.PID == 0 {
+		return ports + " : ERROR : NO-USER"
+	}
+	name, ok := username(c.PID)
+	if !ok {
+		return ports + " : ERROR : HIDDEN-USER"
+	}
+	return ports + " : USERID : UNIX : " + name
+}
+
+// parseQuery parses "<port-on-server> , <port-on-client>".
+func parseQuery(q string) (uint16, uint16, bool) {
+	server, client, ok := strings.Cut(strings.TrimSpace(q), ",")
+	if !ok {
+		return 0, 0, false
+	}
+	s, err := strconv.ParseUint(strings.TrimSpace(server), 10, 16)
+	if err != nil || s == 0 {
+		return 0, 0, false
+	}
+	c, err := strconv.ParseUint(strings.TrimSpace(client), 10, 16)
+	if err != nil || c == 0 {
+		return 0, 0, false
+	}
+	return uint16(s), uint16(c), true
+}
+
+// username gives the owner of a process. Only works where there is a /proc.
+func username(pid uint) (string, bool) {
+	fi, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+	if err != nil {
+		return "", false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	uid := strconv.FormatUint(uint64(st.Uid), 10)
+	if u, err := user.LookupId(uid); err == nil {
+		return u.Username, true
+	}
+	return uid, true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/alicebob/procspy"
+)
+
+func TestAnswer(t *testing.T) {
+	var (
+		local  = netip.MustParseAddr("10.0.0.1")
+		remote = netip.MustParseAddr("10.0.0.2")
+		owner  = func(l, r netip.AddrPort) (procspy.Connection, error) {
+			if l.Addr() != local || r.Addr() != remote {
+				t.Fatalf("wrong addresses: %v %v", l, r)
+			}
+			switch {
+			case l.Port() == 6193 && r.Port() == 23:
+				return procspy.Connection{Proc: procspy.Proc{PID: 42}}, nil
+			case l.Port() == 6195 && r.Port() == 23:
+				return procspy.Connection{Proc: procspy.Proc{PID: 43}}, nil
+			case l.Port() == 6196 && r.Port() == 23:
+				return procspy.Connection{}, nil
+			}
+			return procspy.Connection{}, procspy.ErrNoConnection
+		}
+		username = func(pid uint) (string, bool) {
+			return "alice", pid == 42
+		}
+	)
+	for query, want := range map[string]string{
+		"6193, 23\r\n":  "6193 , 23 : USERID : UNIX : alice",
+		" 6193 ,23\n":   "6193 , 23 : USERID : UNIX : alice",
+		"6195, 23\r\n":  "6195 , 23 : ERROR : HIDDEN-USER",
+		"6196, 23\r\n":  "6196 , 23 : ERROR : NO-USER",
+		"6194, 23\r\n":  "6194 , 23 : ERROR : NO-USER",
+		"6194\r\n":      "6194 : ERROR : INVALID-PORT",
+		"0, 23\r\n":     "0, 23 : ERROR : INVALID-PORT",
+		"6194, 70000\n": "6194, 70000 : ERROR : INVALID-PORT",
+	} {
+		if have := answer(query, local, remote, owner, username); have != want {
+			t.Errorf("%q: have %q, want %q", query, have, want)
+		}
+	}
+}
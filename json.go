@@ -0,0 +1,181 @@
+package procspy
+
+// The JSON encoding. Changes which would break a consumer need a new
+// JSONVersion.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// JSONVersion is the version of the JSON encoding of Connection and Snapshot.
+// Every encoded connection and snapshot has it in its "version" field.
+const JSONVersion = 1
+
+// jsonConnection is how a Connection looks in JSON:
+//
+//	{
+//	  "version": 1,
+//	  "transport": "tcp",
+//	  "local_address": "10.0.0.1",
+//	  "local_port": 80,
+//	  "remote_address": "fe80::1%eth0",
+//	  "remote_port": 41234,
+//	  "state": "ESTABLISHED",
+//	  "recv_q": 0,
+//	  "send_q": 1448,
+//	  "netns": 4026531840,
+//	  "inode": 12345,
+//	  "process": {"pid": 42, "name": "nginx"}
+//	}
+//
+// recv_q and send_q are left out when they're 0, and netns, inode, and
+// process when they're unknown.
+type jsonConnection struct {
+	Version       int    `json:"version"`
+	Transport     string `json:"transport"`
+	LocalAddress  string `json:"local_address"`
+	LocalPort     uint16 `json:"local_port"`
+	RemoteAddress string `json:"remote_address"`
+	RemotePort    uint16 `json:"remote_port"`
+	State         State  `json:"state"`
+	RecvQ         uint32 `json:"recv_q,omitempty"`
+	SendQ         uint32 `json:"send_q,omitempty"`
+	NetNS         uint64 `json:"netns,omitempty"`
+	Inode         uint64 `json:"inode,omitempty"`
+	Process       *Proc  `json:"process,omitempty"`
+}
+
+// jsonSnapshot is how a Snapshot looks in JSON. The processes and indexes
+// are derived from the connections.
+type jsonSnapshot struct {
+	Version     int          `json:"version"`
+	Time        time.Time    `json:"time"`
+	Namespaces  []uint64     `json:"namespaces"`
+	Connections []Connection `json:"connections"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (c Connection) MarshalJSON() ([]byte, error) {
+	j := jsonConnection{
+		Version:       JSONVersion,
+		Transport:     c.Transport,
+		LocalAddress:  jsonIP(c.LocalAddress, c.localZone),
+		LocalPort:     c.LocalPort,
+		RemoteAddress: jsonIP(c.RemoteAddress, c.remoteZone),
+		RemotePort:    c.RemotePort,
+		State:         c.State,
+		RecvQ:         c.RecvQ,
+		SendQ:         c.SendQ,
+		NetNS:         c.NetNS,
+		Inode:         c.inode,
+	}
+	if c.PID != 0 {
+		p := c.Proc
+		j.Process = &p
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *Connection) UnmarshalJSON(b []byte) error {
+	var j jsonConnection
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	if err := checkJSONVersion(j.Version); err != nil {
+		return err
+	}
+	n := Connection{
+		Transport:  j.Transport,
+		LocalPort:  j.LocalPort,
+		RemotePort: j.RemotePort,
+		State:      j.State,
+		RecvQ:      j.RecvQ,
+		SendQ:      j.SendQ,
+		NetNS:      j.NetNS,
+		inode:      j.Inode,
+	}
+	var err error
+	if n.LocalAddress, n.localZone, err = parseJSONIP(j.LocalAddress); err != nil {
+		return err
+	}
+	if n.RemoteAddress, n.remoteZone, err = parseJSONIP(j.RemoteAddress); err != nil {
+		return err
+	}
+	if j.Process != nil {
+		n.Proc = *j.Process
+	}
+	*c = n
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	j := jsonSnapshot{
+		Version:     JSONVersion,
+		Time:        s.Time,
+		Namespaces:  s.Namespaces,
+		Connections: s.Connections,
+	}
+	if j.Namespaces == nil {
+		j.Namespaces = []uint64{}
+	}
+	if j.Connections == nil {
+		j.Connections = []Connection{}
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Snapshot) UnmarshalJSON(b []byte) error {
+	var j jsonSnapshot
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	if err := checkJSONVersion(j.Version); err != nil {
+		return err
+	}
+	*s = *newSnapshot(j.Connections, j.Time)
+	return nil
+}
+
+func checkJSONVersion(v int) error {
+	if v > JSONVersion {
+		return fmt.Errorf("unsupported JSON version %d", v)
+	}
+	return nil
+}
+
+func jsonIP(ip net.IP, zone string) string {
+	if ip == nil {
+		return ""
+	}
+	if zone != "" {
+		return ip.String() + "%" + zone
+	}
+	return ip.String()
+}
+
+// parseJSONIP parses an IP with an optional zone. IPv4 addresses are 4 bytes
+// long.
+func parseJSONIP(s string) (net.IP, string, error) {
+	if s == "" {
+		return nil, "", nil
+	}
+	var zone string
+	if i := strings.IndexByte(s, '%'); i != -1 {
+		s, zone = s[:i], s[i+1:]
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, "", fmt.Errorf("invalid IP %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil && !strings.Contains(s, ":") {
+		ip = ip4
+	}
+	return ip, zone, nil
+}
@@ -0,0 +1,133 @@
+package procspy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectionJSON(t *testing.T) {
+	p := NewProcNet(fixture, 0)
+	p.SetByteOrder(binary.LittleEndian)
+	var cs []Connection
+	for c := p.Next(); c != nil; c = p.Next() {
+		c.Transport = "tcp"
+		c.NetNS = 4026531840
+		cs = append(cs, c.clone())
+	}
+	cs[0].Proc = Proc{PID: 42, Name: "nginx"}
+	cs = append(cs, Connection{
+		Transport:     "tcp",
+		LocalAddress:  net.ParseIP("fe80::1"),
+		localZone:     "en0",
+		LocalPort:     22,
+		RemoteAddress: net.ParseIP("10.0.0.2").To4(),
+		RemotePort:    50000,
+		State:         State(99),
+		SendQ:         1448,
+	})
+
+	for _, c := range cs {
+		b, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var have Connection
+		if err := json.Unmarshal(b, &have); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have, c) {
+			t.Errorf("have\n%+v\nwant\n%+v\n%s", have, c, b)
+		}
+	}
+
+	b, err := json.Marshal(cs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(b), `{"version":1,"transport":"tcp","local_address":"0.0.0.0","local_port":42688,"remote_address":"0.0.0.0","remote_port":0,"state":"ESTABLISHED","netns":4026531840,"inode":5107,"process":{"pid":42,"name":"nginx"}}`; have != want {
+		t.Errorf("have\n%s\nwant\n%s", have, want)
+	}
+
+	b, err = json.Marshal(cs[len(cs)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(b), `{"version":1,"transport":"tcp","local_address":"fe80::1%en0","local_port":22,"remote_address":"10.0.0.2","remote_port":50000,"state":"UNKNOWN(99)","send_q":1448}`; have != want {
+		t.Errorf("have\n%s\nwant\n%s", have, want)
+	}
+
+	for _, in := range []string{
+		`{"version":2,"transport":"tcp"}`,
+		`{"local_address":"nonsense"}`,
+		`{"state":"NONSENSE"}`,
+		`{"state":"UNKNOWN(300)"}`,
+	} {
+		var c Connection
+		if err := json.Unmarshal([]byte(in), &c); err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	s := newSnapshot([]Connection{
+		testConnection(StateListen, "0.0.0.0:80", "0.0.0.0:0", 1, Proc{PID: 12, Name: "nginx"}),
+		testConnection(StateEstablished, "10.0.0.1:80", "10.0.0.2:4000", 1, Proc{PID: 12, Name: "nginx"}),
+		testConnection(StateEstablished, "10.0.0.1:4001", "10.0.0.3:443", 2, Proc{}),
+	}, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), `{"version":1,"time":"2026-10-19T12:00:00Z","namespaces":[1,2],"connections":[{`) {
+		t.Errorf("have %s", b)
+	}
+	var have Snapshot
+	if err := json.Unmarshal(b, &have); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&have, s) {
+		t.Errorf("have\n%+v\nwant\n%+v", have, s)
+	}
+	if have, want := len(have.ByPID(12)), 2; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	b, err = json.Marshal(newSnapshot(nil, time.Time{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(b), `{"version":1,"time":"0001-01-01T00:00:00Z","namespaces":[],"connections":[]}`; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+func TestUnixPeerJSON(t *testing.T) {
+	p := UnixPeer{
+		Proc:    Proc{PID: 42, Name: "nginx"},
+		UID:     33,
+		GID:     34,
+		Cmdline: []string{"nginx", "-g", "daemon off;"},
+		Label:   "unconfined",
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(b), `{"pid":42,"name":"nginx","uid":33,"gid":34,"cmdline":["nginx","-g","daemon off;"],"label":"unconfined"}`; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	var have UnixPeer
+	if err := json.Unmarshal(b, &have); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have, p) {
+		t.Errorf("have %+v, want %+v", have, p)
+	}
+}
@@ -0,0 +1,103 @@
+package procspy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// ErrNoProcess is returned by PIDConnections() on Linux if the process
+// doesn't exist, or if we're not allowed to look at it.
+var ErrNoProcess = errors.New("no such process, or no access")
+
+// ErrNoConnection is returned by ConnOwner() and PeerProc() if there is no
+// socket with the given addresses.
+var ErrNoConnection = errors.New("no such connection")
+
+// PortOwners returns the sockets bound to a local address and port, in any
+// state, with their owning process filled in when it can be found. An
+// unspecified address (0.0.0.0 or ::) matches every local address, and
+// sockets bound to an unspecified address match every address. This is a lot
+// cheaper than a full scan: on Linux only the socket tables of our own
+// network namespace are read, and processes are only looked at until all
+// sockets are found.
+func PortOwners(local netip.AddrPort) ([]Connection, error) {
+	return lookupPort(local)
+}
+
+// PIDConnections returns all sockets of a single process, in any state. On
+// Linux only the fds and the network namespace of that process are looked
+// at.
+func PIDConnections(pid uint) ([]Connection, error) {
+	return lookupPID(pid)
+}
+
+// ConnOwner returns the socket with exactly the given local and remote
+// address, with its owning process filled in when it can be found. Only
+// sockets in our own network namespace are looked at. This is what an ident
+// server needs.
+func ConnOwner(local, remote netip.AddrPort) (Connection, error) {
+	cs, err := lookupConn(local, remote)
+	if err != nil {
+		return Connection{}, err
+	}
+	if len(cs) == 0 {
+		return Connection{}, ErrNoConnection
+	}
+	// A TIME_WAIT socket can linger next to a new one with the same
+	// addresses. Prefer the one with an owner.
+	for _, c := range cs {
+		if c.PID != 0 {
+			return c, nil
+		}
+	}
+	return cs[0], nil
+}
+
+// PeerProc returns the process on the other end of a TCP connection, such as
+// one you got from Accept(). The peer has to be on this host, for example
+// connected over loopback. The Proc is empty if the peer socket is found, but
+// its owner isn't, usually because we're not allowed to look at it.
+func PeerProc(c net.Conn) (Proc, error) {
+	local, err := tcpAddrPort(c.LocalAddr())
+	if err != nil {
+		return Proc{}, err
+	}
+	remote, err := tcpAddrPort(c.RemoteAddr())
+	if err != nil {
+		return Proc{}, err
+	}
+	// Seen from the peer local and remote are the other way around.
+	peer, err := ConnOwner(remote, local)
+	return peer.Proc, err
+}
+
+func tcpAddrPort(a net.Addr) (netip.AddrPort, error) {
+	ta, ok := a.(*net.TCPAddr)
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("not a TCP address: %v", a)
+	}
+	return ta.AddrPort(), nil
+}
+
+// matchConn is the address matching of ConnOwner().
+func matchConn(c *Connection, local, remote netip.AddrPort) bool {
+	return unzone(c.Local()) == unzone(local) && unzone(c.Remote()) == unzone(remote)
+}
+
+func unzone(a netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(a.Addr().Unmap().WithZone(""), a.Port())
+}
+
+// matchLocal is the address matching of PortOwners().
+func matchLocal(have, want netip.AddrPort) bool {
+	if have.Port() != want.Port() {
+		return false
+	}
+	var (
+		h = have.Addr().Unmap().WithZone("")
+		w = want.Addr().Unmap().WithZone("")
+	)
+	return !w.IsValid() || w.IsUnspecified() || h.IsUnspecified() || h == w
+}
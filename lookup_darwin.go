@@ -0,0 +1,39 @@
+package procspy
+
+import (
+	"net/netip"
+)
+
+// Darwin has no cheaper way than a full scan.
+
+func lookupPort(local netip.AddrPort) ([]Connection, error) {
+	return lookup(func(c *Connection) bool {
+		return matchLocal(c.Local(), local)
+	})
+}
+
+func lookupConn(local, remote netip.AddrPort) ([]Connection, error) {
+	return lookup(func(c *Connection) bool {
+		return matchConn(c, local, remote)
+	})
+}
+
+func lookupPID(pid uint) ([]Connection, error) {
+	return lookup(func(c *Connection) bool {
+		return c.PID == pid
+	})
+}
+
+func lookup(match func(*Connection) bool) ([]Connection, error) {
+	cs, err := CurrentBackend().Connections(true, true)
+	if err != nil {
+		return nil, err
+	}
+	var res []Connection
+	for c := cs.Next(); c != nil; c = cs.Next() {
+		if match(c) {
+			res = append(res, c.clone())
+		}
+	}
+	return res, cs.Err()
+}
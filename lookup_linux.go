@@ -0,0 +1,108 @@
+package procspy
+
+import (
+	"net/netip"
+	"strconv"
+)
+
+func lookupPort(local netip.AddrPort) ([]Connection, error) {
+	return lookupSelf(func(c *Connection) bool {
+		return matchLocal(c.Local(), local)
+	})
+}
+
+func lookupConn(local, remote netip.AddrPort) ([]Connection, error) {
+	return lookupSelf(func(c *Connection) bool {
+		return matchConn(c, local, remote)
+	})
+}
+
+// lookupSelf finds the matching sockets in our own network namespace, and
+// then looks for their owners until all are found.
+func lookupSelf(match func(*Connection) bool) ([]Connection, error) {
+	var (
+		res  []Connection
+		want = map[uint64]int{} // inode -> index in res
+		it   = newPnConnIter(defaultFiles, selfTables(defaultFiles), nil, true, nil)
+	)
+	for c := it.Next(); c != nil; c = it.Next() {
+		if !match(c) {
+			continue
+		}
+		if c.inode != 0 {
+			// TIME_WAIT sockets have no inode, nor an owner.
+			want[c.inode] = len(res)
+		}
+		res = append(res, c.clone())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if len(want) == 0 {
+		return res, nil
+	}
+
+	dirNames, err := defaultFiles.readDirNames(".")
+	if err != nil {
+		return nil, err
+	}
+	for _, dirName := range dirNames {
+		pid, err := strconv.ParseUint(dirName, 10, 0)
+		if err != nil {
+			// Not a number, so not a PID subdir.
+			continue
+		}
+		p := scanProc(defaultFiles, dirName)
+		if p == nil {
+			continue
+		}
+		for _, inode := range p.inodes {
+			if i, ok := want[inode]; ok {
+				res[i].Proc = Proc{
+					PID:  uint(pid),
+					Name: p.name,
+				}
+				delete(want, inode)
+			}
+		}
+		if len(want) == 0 {
+			break
+		}
+	}
+	return res, nil
+}
+
+func lookupPID(pid uint) ([]Connection, error) {
+	dirName := strconv.FormatUint(uint64(pid), 10)
+	p := scanProc(defaultFiles, dirName)
+	if p == nil {
+		return nil, ErrNoProcess
+	}
+	if len(p.inodes) == 0 {
+		return nil, nil
+	}
+
+	var (
+		res    []Connection
+		inodes = make(map[uint64]struct{}, len(p.inodes))
+		proc   = Proc{
+			PID:  pid,
+			Name: p.name,
+		}
+		it = newPnConnIter(defaultFiles, []netTable{
+			{dirName + "/net/tcp", p.netns},
+			{dirName + "/net/tcp6", p.netns},
+		}, nil, true, nil)
+	)
+	for _, inode := range p.inodes {
+		inodes[inode] = struct{}{}
+	}
+	for c := it.Next(); c != nil; c = it.Next() {
+		if _, ok := inodes[c.inode]; !ok {
+			continue
+		}
+		c.Proc = proc
+		res = append(res, c.clone())
+	}
+	return res, it.Err()
+}
@@ -0,0 +1,86 @@
+package procspy
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestLookups(t *testing.T) {
+	root := t.TempDir()
+	inodes := makeProcTree(t, root, []fakeProc{
+		{pid: 42, name: "nginx", start: 100, netns: "a", sockets: 2},
+		{pid: 43, name: "redis", start: 200, netns: "a", sockets: 1},
+		{pid: 44, name: "envoy", start: 300, netns: "b", sockets: 1},
+	})
+	defer SetProcRoot(procRoot)
+	SetProcRoot(root)
+
+	for addr, want := range map[string][]Proc{
+		"127.0.0.1:10672": {{PID: 42, Name: "nginx"}},
+		"0.0.0.0:10673":   {{PID: 42, Name: "nginx"}},
+		"[::]:10688":      {{PID: 43, Name: "redis"}},
+		"10.0.0.1:10688":  nil,
+		"127.0.0.1:10704": nil,  // other namespace
+		"0.0.0.0:42688":   {{}}, // in the fixture, no owner
+	} {
+		cs, err := PortOwners(netip.MustParseAddrPort(addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var have []Proc
+		for _, c := range cs {
+			have = append(have, c.Proc)
+		}
+		if len(have) != len(want) || (len(want) > 0 && have[0] != want[0]) {
+			t.Errorf("%s: have %v, want %v", addr, have, want)
+		}
+	}
+
+	c, err := ConnOwner(
+		netip.MustParseAddrPort("127.0.0.1:10688"),
+		netip.MustParseAddrPort("[::ffff:127.0.0.1]:8080"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := c.Proc, (Proc{PID: 43, Name: "redis"}); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if _, err := ConnOwner(
+		netip.MustParseAddrPort("127.0.0.1:10688"),
+		netip.MustParseAddrPort("127.0.0.1:8081"),
+	); err != ErrNoConnection {
+		t.Errorf("have %v, want %v", err, ErrNoConnection)
+	}
+
+	cs, err := PIDConnections(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(cs), 2; have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	for i, c := range cs {
+		if have, want := c.inode, inodes[42][i]; have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+		if have, want := c.Proc, (Proc{PID: 42, Name: "nginx"}); have != want {
+			t.Errorf("have %v, want %v", have, want)
+		}
+	}
+
+	cs, err = PIDConnections(44)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(cs), 1; have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	if have, want := cs[0].Local(), netip.MustParseAddrPort("127.0.0.1:10704"); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	if _, err := PIDConnections(99); err != ErrNoProcess {
+		t.Errorf("have %v, want %v", err, ErrNoProcess)
+	}
+}
@@ -0,0 +1,46 @@
+package procspy
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+)
+
+func TestPeerProc(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// We're on both ends.
+	for _, c := range []net.Conn{server, client} {
+		p, err := PeerProc(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := p.PID, uint(os.Getpid()); have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+	}
+
+	_, err = ConnOwner(
+		netip.MustParseAddrPort("127.0.0.1:1"),
+		client.LocalAddr().(*net.TCPAddr).AddrPort(),
+	)
+	if have, want := err, ErrNoConnection; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
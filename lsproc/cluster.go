@@ -0,0 +1,296 @@
+package main
+
+// lsproc agent and lsproc collect: connections of several hosts.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alicebob/procspy"
+)
+
+const agentUsage = `usage: lsproc agent -collector <url> [flags]
+
+Sends a snapshot of all connections, with their processes and the NAT table,
+to an lsproc collect every -interval. Run it as root.
+
+flags:
+`
+
+const collectUsage = `usage: lsproc collect [flags]
+
+Receives snapshots from lsproc agents, and joins the connections of all hosts:
+  POST /v1/snapshots                    a procspy.HostSnapshot, from an agent
+  GET /v1/hosts                         the hosts, and when we last heard of them
+  GET /v1/cluster                       the joined connections, see
+                                        procspy.ClusterMap
+  GET /v1/graph[?format=dot|mermaid|json]
+                                        the joined connections as a graph
+
+flags:
+`
+
+// maxSnapshot is the largest snapshot a collector accepts.
+const maxSnapshot = 64 << 20
+
+func agentCmd(args []string) {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, agentUsage)
+		fs.PrintDefaults()
+	}
+	hostname, _ := os.Hostname()
+	var (
+		collector = fs.String("collector", "", "URL of the collector, such as http://10.0.0.1:9668")
+		host      = fs.String("host", hostname, "name of this host")
+		tokenFile = fs.String("token-file", "", "file with the token of the collector")
+		interval  = fs.Duration("interval", 10*time.Second, "how often to send a snapshot")
+		once      = fs.Bool("once", false, "send a single snapshot, and exit")
+	)
+	fs.Parse(args)
+	if fs.NArg() != 0 || *collector == "" || *host == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	token, err := readToken(*tokenFile)
+	if err != nil {
+		die(err)
+	}
+	a := &agent{
+		scanner:   &procspy.Scanner{},
+		nat:       procspy.ReadNAT,
+		collector: strings.TrimSuffix(*collector, "/"),
+		host:      *host,
+		token:     token,
+	}
+	if *once {
+		die(a.push(context.Background()))
+		return
+	}
+	for {
+		if err := a.push(context.Background()); err != nil {
+			log.Printf("push: %s", err)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+type agent struct {
+	scanner   *procspy.Scanner
+	nat       func() ([]procspy.NATEntry, error)
+	collector string
+	host      string
+	token     string // "" is no auth
+}
+
+// push sends a snapshot to the collector.
+func (a *agent) push(ctx context.Context) error {
+	snap, err := a.scanner.Snapshot(true)
+	if err != nil {
+		return err
+	}
+	nat, err := a.nat()
+	if err != nil {
+		// Still useful without.
+		log.Printf("NAT: %s", err)
+	}
+	b, err := json.Marshal(procspy.HostSnapshot{Host: a.host, Snapshot: snap, NAT: nat})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.collector+"/v1/snapshots", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&e)
+		return fmt.Errorf("%s: %s %s", a.collector, res.Status, e.Error)
+	}
+	return nil
+}
+
+func collectCmd(args []string) {
+	fs := flag.NewFlagSet("collect", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, collectUsage)
+		fs.PrintDefaults()
+	}
+	var (
+		listen    = fs.String("listen", "127.0.0.1:9668", "address to listen on. Other than loopback needs -token-file")
+		tokenFile = fs.String("token-file", "", "file with a token, which agents and clients have to send as \"Authorization: Bearer <token>\"")
+		expire    = fs.Duration("expire", time.Minute, "forget hosts which didn't send a snapshot for this long")
+	)
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	token, err := readToken(*tokenFile)
+	if err != nil {
+		die(err)
+	}
+	if token == "" && !loopback(*listen) {
+		die(fmt.Errorf("-listen %s needs a -token-file", *listen))
+	}
+	c := newCollector(token, *expire)
+	die(http.ListenAndServe(*listen, c.handler()))
+}
+
+// loopback is whether a listen address is only reachable from this host.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	a, err := netip.ParseAddr(host)
+	return err == nil && a.IsLoopback()
+}
+
+type collector struct {
+	token  string // "" is no auth
+	expire time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]received
+}
+
+// received is the last snapshot of a host.
+type received struct {
+	snap procspy.HostSnapshot
+	at   time.Time
+}
+
+func newCollector(token string, expire time.Duration) *collector {
+	return &collector{
+		token:  token,
+		expire: expire,
+		now:    time.Now,
+		hosts:  map[string]received{},
+	}
+}
+
+func (c *collector) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/snapshots", method("POST", c.receive))
+	mux.HandleFunc("/v1/hosts", method("GET", c.listHosts))
+	mux.HandleFunc("/v1/cluster", method("GET", c.cluster))
+	mux.HandleFunc("/v1/graph", method("GET", c.graph))
+	return auth(c.token, mux)
+}
+
+func (c *collector) receive(w http.ResponseWriter, r *http.Request) {
+	var hs procspy.HostSnapshot
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnapshot)).Decode(&hs); err != nil {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("invalid snapshot: %w", err))
+		return
+	}
+	if hs.Host == "" || hs.Snapshot == nil {
+		httpError(w, http.StatusBadRequest, errors.New("need a host and a snapshot"))
+		return
+	}
+	c.mu.Lock()
+	c.hosts[hs.Host] = received{snap: hs, at: c.now()}
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// current gives the snapshots which didn't expire, sorted on host.
+func (c *collector) current() []received {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var (
+		now = c.now()
+		res []received
+	)
+	for h, r := range c.hosts {
+		if c.expire > 0 && now.Sub(r.at) > c.expire {
+			delete(c.hosts, h)
+			continue
+		}
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].snap.Host < res[j].snap.Host })
+	return res
+}
+
+func (c *collector) join() *procspy.ClusterMap {
+	var hosts []procspy.HostSnapshot
+	for _, r := range c.current() {
+		hosts = append(hosts, r.snap)
+	}
+	return procspy.JoinHosts(hosts)
+}
+
+// jsonHost is a host in /v1/hosts.
+type jsonHost struct {
+	Host        string    `json:"host"`
+	Received    time.Time `json:"received"`
+	Connections int       `json:"connections"`
+}
+
+func (c *collector) listHosts(w http.ResponseWriter, r *http.Request) {
+	hosts := []jsonHost{}
+	for _, r := range c.current() {
+		hosts = append(hosts, jsonHost{
+			Host:        r.snap.Host,
+			Received:    r.at,
+			Connections: len(r.snap.Snapshot.Connections),
+		})
+	}
+	writeJSON(w, hosts)
+}
+
+func (c *collector) cluster(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.join())
+}
+
+func (c *collector) graph(w http.ResponseWriter, r *http.Request) {
+	var write func(*procspy.Graph, io.Writer) error
+	switch f := r.URL.Query().Get("format"); f {
+	case "", "dot":
+		write = (*procspy.Graph).WriteDOT
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+	case "mermaid":
+		write = (*procspy.Graph).WriteMermaid
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	case "json":
+		writeJSON(w, c.join().Graph())
+		return
+	default:
+		httpError(w, http.StatusBadRequest, fmt.Errorf("unknown graph format %q", f))
+		return
+	}
+	if err := write(c.join().Graph(), w); err != nil {
+		log.Printf("write: %s", err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/procspy"
+)
+
+func TestLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:9668": true,
+		"[::1]:9668":     true,
+		"localhost:9668": true,
+		":9668":          false,
+		"0.0.0.0:9668":   false,
+		"10.0.0.1:9668":  false,
+		"collector:9668": false,
+		"127.0.0.1":      false,
+	} {
+		if have := loopback(addr); have != want {
+			t.Errorf("%q: have %t, want %t", addr, have, want)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	var (
+		now = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		c   = newCollector("s3cret", time.Minute)
+	)
+	c.now = func() time.Time { return now }
+	srv := httptest.NewServer(c.handler())
+	defer srv.Close()
+
+	noNAT := func() ([]procspy.NATEntry, error) { return nil, nil }
+	agents := []*agent{
+		{
+			host: "web",
+			scanner: &procspy.Scanner{Backend: procspy.Fixtures{
+				conn(procspy.StateListen, "0.0.0.0:443", "0.0.0.0:0", 42, "nginx"),
+				conn(procspy.StateEstablished, "10.0.0.1:443", "192.168.1.5:50123", 42, "nginx"),
+				conn(procspy.StateEstablished, "10.0.0.1:40001", "10.0.0.2:80", 42, "nginx"),
+			}},
+			nat: noNAT,
+		},
+		{
+			host: "app",
+			scanner: &procspy.Scanner{Backend: procspy.Fixtures{
+				conn(procspy.StateListen, "0.0.0.0:8080", "0.0.0.0:0", 43, "app"),
+				conn(procspy.StateEstablished, "172.17.0.2:8080", "10.0.0.1:40001", 43, "app"),
+				conn(procspy.StateEstablished, "10.0.0.2:40003", "10.0.0.3:5432", 43, "app"),
+			}},
+			nat: func() ([]procspy.NATEntry, error) {
+				return []procspy.NATEntry{{
+					OrigSrc:  netip.MustParseAddrPort("10.0.0.1:40001"),
+					OrigDst:  netip.MustParseAddrPort("10.0.0.2:80"),
+					ReplySrc: netip.MustParseAddrPort("172.17.0.2:8080"),
+					ReplyDst: netip.MustParseAddrPort("10.0.0.1:40001"),
+				}}, nil
+			},
+		},
+		{
+			host: "db",
+			scanner: &procspy.Scanner{Backend: procspy.Fixtures{
+				conn(procspy.StateListen, "0.0.0.0:5432", "0.0.0.0:0", 44, "postgres"),
+				conn(procspy.StateEstablished, "10.0.0.3:5432", "10.0.0.2:40003", 44, "postgres"),
+			}},
+			nat: noNAT,
+		},
+	}
+	for _, a := range agents {
+		a.collector = srv.URL
+		a.token = "s3cret"
+		if err := a.push(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("token", func(t *testing.T) {
+		a := *agents[0]
+		a.token = "wrong"
+		if err := a.push(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("have %v", err)
+		}
+	})
+
+	t.Run("method", func(t *testing.T) {
+		var e map[string]string
+		if have, want := get(t, srv.URL+"/v1/snapshots", "s3cret", &e), 405; have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+	})
+
+	t.Run("hosts", func(t *testing.T) {
+		var hosts []jsonHost
+		if have, want := get(t, srv.URL+"/v1/hosts", "s3cret", &hosts), 200; have != want {
+			t.Fatalf("have %d, want %d", have, want)
+		}
+		if have, want := len(hosts), 3; have != want {
+			t.Fatalf("have %d, want %d", have, want)
+		}
+		if have, want := hosts[0].Host, "app"; have != want {
+			t.Errorf("have %q, want %q", have, want)
+		}
+	})
+
+	t.Run("cluster", func(t *testing.T) {
+		var m procspy.ClusterMap
+		if have, want := get(t, srv.URL+"/v1/cluster", "s3cret", &m), 200; have != want {
+			t.Fatalf("have %d, want %d", have, want)
+		}
+		if have, want := len(m.Links), 3; have != want {
+			t.Fatalf("have %d, want %d: %+v", have, want, m.Links)
+		}
+		l := m.Links[0]
+		if l.Client.Host != "web" || l.Server.Host != "app" || !l.NAT || l.Server.Proc.Name != "app" {
+			t.Errorf("have %+v", l)
+		}
+	})
+
+	t.Run("graph", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", srv.URL+"/v1/graph?format=mermaid", nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		if have, want := string(b), `flowchart LR
+	n0(["192.168.1.5"])
+	n1["app/app"]
+	n2["db/postgres"]
+	n3["web/nginx"]
+	n0 -->|"443"| n3
+	n1 -->|"5432"| n2
+	n3 -->|"8080"| n1
+`; have != want {
+			t.Errorf("have\n%s\nwant\n%s", have, want)
+		}
+
+		var e map[string]string
+		if have, want := get(t, srv.URL+"/v1/graph?format=png", "s3cret", &e), 400; have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+		var hosts []jsonHost
+		get(t, srv.URL+"/v1/hosts", "s3cret", &hosts)
+		if have, want := len(hosts), 0; have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+	})
+}
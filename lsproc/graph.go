@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alicebob/procspy"
+)
+
+const graphUsage = `usage: lsproc [flags] graph [graph flags] [dot|mermaid|json]
+
+Prints which processes talk to which hosts and ports, as a Graphviz DOT file
+(the default), a Mermaid flowchart, or JSON. The flags of lsproc, such as -f
+and -a, select the connections.
+
+graph flags:
+`
+
+func graphCmd(args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, graphUsage)
+		fs.PrintDefaults()
+	}
+	var (
+		containers = fs.Bool("containers", false, "a node per container, instead of per process")
+		bits4      = fs.Int("host-bits", 0, "collapse remote IPv4 hosts to networks of this size, such as 24")
+		bits6      = fs.Int("host-bits6", 0, "collapse remote IPv6 hosts to networks of this size, such as 64")
+	)
+	fs.Parse(args)
+	graphFormat := "dot"
+	if *format == "json" {
+		graphFormat = "json"
+	}
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		graphFormat = fs.Arg(0)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	switch graphFormat {
+	case "dot", "mermaid", "json":
+	default:
+		fail(fmt.Sprintf("unknown graph format %q", graphFormat))
+	}
+	if *bits4 < 0 || *bits4 > 32 || *bits6 < 0 || *bits6 > 128 {
+		fail("invalid -host-bits")
+	}
+
+	f, err := flagFilter(false)
+	if err != nil {
+		return err
+	}
+	cs, err := procspy.Select(f, true)
+	if err != nil {
+		return err
+	}
+	g, err := procspy.NewGraph(cs, procspy.GraphOptions{
+		Containers: *containers,
+		HostBits4:  *bits4,
+		HostBits6:  *bits6,
+	})
+	if err != nil {
+		return err
+	}
+	switch graphFormat {
+	case "dot":
+		return g.WriteDOT(os.Stdout)
+	case "mermaid":
+		return g.WriteMermaid(os.Stdout)
+	default:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(g)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+// lsproc history: what was connected when.
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alicebob/procspy"
+)
+
+const historyUsage = `usage:
+  lsproc history [history flags] record
+                      take a snapshot every -interval, and keep it in -dir
+  lsproc [flags] history [history flags]
+                      the connections in -dir
+
+Without -at it lists every connection which was there between -from and -to,
+with when it was first and last seen. With -at it gives the connections at
+that time. Times are RFC 3339, such as 2024-01-02T15:04:05Z, "2024-01-02
+15:04:05" in the local time zone, or a duration ago, such as 10m. The flags of
+lsproc, such as -f and -a, select the connections. For example, who talked to
+10.0.0.2 ten minutes ago:
+
+  lsproc -f 'rnet 10.0.0.2' history -at 10m
+
+history flags:
+`
+
+func historyCmd(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, historyUsage)
+		fs.PrintDefaults()
+	}
+	var (
+		dir      = fs.String("dir", "/var/lib/lsproc/history", "directory with the snapshots")
+		interval = fs.Duration("interval", 10*time.Second, "how often record takes a snapshot")
+		maxAge   = fs.Duration("max-age", 7*24*time.Hour, "record removes snapshots older than this, 0 keeps them")
+		maxBytes = fs.String("max-bytes", "1G", "record removes the oldest snapshots when -dir is bigger than this, such as 500M. 0 is no limit")
+		at       = fs.String("at", "", "the connections at this time")
+		from     = fs.String("from", "", "connections since this time")
+		to       = fs.String("to", "", "connections until this time")
+	)
+	fs.Parse(args)
+
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		if fs.Arg(0) != "record" {
+			fs.Usage()
+			os.Exit(2)
+		}
+		size, err := parseSize(*maxBytes)
+		if err != nil {
+			fail(err.Error())
+		}
+		return recordHistory(*dir, *interval, procspy.StoreOptions{MaxAge: *maxAge, MaxBytes: size})
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	now := time.Now()
+	var times [3]time.Time
+	for i, s := range []string{*at, *from, *to} {
+		t, err := parseWhen(s, now)
+		if err != nil {
+			fail(err.Error())
+		}
+		times[i] = t
+	}
+	if *at != "" && (*from != "" || *to != "") {
+		fail("-at can't be used with -from or -to")
+	}
+	f, err := flagFilter(false)
+	if err != nil {
+		return err
+	}
+	if f.ReadsProc() {
+		fail("uid and container can't be used with history")
+	}
+	if _, err := os.Stat(*dir); err != nil {
+		return err
+	}
+	store, err := procspy.OpenStore(*dir, procspy.StoreOptions{})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if *at != "" {
+		snap, err := store.At(times[0])
+		if err != nil {
+			if errors.Is(err, procspy.ErrNoSnapshot) {
+				return fmt.Errorf("no snapshot of %s or before", times[0].Format(time.RFC3339))
+			}
+			return err
+		}
+		return outputAt(f.Iter(procspy.SliceIter(snap.Connections)), true, snap.Time)
+	}
+	tcs, err := store.Query(times[1], times[2], f)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tcs)
+	case "ndjson":
+		enc := json.NewEncoder(os.Stdout)
+		for _, tc := range tcs {
+			if err := enc.Encode(tc); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		var resolve func(netip.Addr) string
+		if !*numeric {
+			resolve = resolver()
+		}
+		return historyTable(os.Stdout, tcs, resolve)
+	}
+}
+
+// recordHistory adds a snapshot to the store every interval, forever.
+func recordHistory(dir string, interval time.Duration, opts procspy.StoreOptions) error {
+	store, err := procspy.OpenStore(dir, opts)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	scanner := &procspy.Scanner{}
+	for {
+		snap, err := scanner.Snapshot(true)
+		if err != nil {
+			log.Printf("scan: %s", err)
+		} else if err := store.Add(snap); err != nil {
+			log.Printf("store: %s", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// historyTable is table() with when the connections were seen.
+func historyTable(w io.Writer, tcs []procspy.TrackedConnection, resolve func(netip.Addr) string) error {
+	const layout = "2006-01-02 15:04:05"
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "First Seen\tLast Seen\tState\tLocal Address:Port\tPeer Address:Port\tPID/Program")
+	for i := range tcs {
+		c := &tcs[i].Connection
+		p := "-"
+		if c.PID != 0 {
+			p = strconv.FormatUint(uint64(c.PID), 10) + "/" + c.Name
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			tcs[i].FirstSeen.Local().Format(layout),
+			tcs[i].LastSeen.Local().Format(layout),
+			c.State,
+			hostPort(c.Local(), resolve),
+			hostPort(c.Remote(), resolve),
+			p,
+		)
+	}
+	return tw.Flush()
+}
+
+// parseWhen understands RFC 3339, "2006-01-02 15:04:05" in the local time
+// zone, and durations before now. "" is the zero time.
+func parseWhen(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// parseSize understands "100", "100K", "100M", "100G", and "100T", in powers
+// of 1024. A "B" after the unit is fine.
+func parseSize(s string) (int64, error) {
+	n := strings.TrimSuffix(strings.ToUpper(s), "B")
+	shift := 0
+	if n != "" {
+		if i := strings.IndexByte("KMGT", n[len(n)-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			n = n[:len(n)-1]
+		}
+	}
+	v, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || v < 0 || v > (1<<62)>>shift {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return v << shift, nil
+}
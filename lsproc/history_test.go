@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alicebob/procspy"
+)
+
+func TestHistoryTable(t *testing.T) {
+	defer func(l *time.Location) { time.Local = l }(time.Local)
+	time.Local = time.UTC
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tcs := []procspy.TrackedConnection{
+		{
+			Connection: conn(procspy.StateEstablished, "10.0.0.1:80", "10.0.0.2:4000", 12, "nginx"),
+			FirstSeen:  start,
+			LastSeen:   start.Add(time.Minute),
+		},
+		{
+			Connection: conn(procspy.StateTimeWait, "10.0.0.1:40000", "10.0.0.3:443", 0, ""),
+			FirstSeen:  start.Add(10 * time.Second),
+			LastSeen:   start.Add(10 * time.Second),
+		},
+	}
+	var b bytes.Buffer
+	if err := historyTable(&b, tcs, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `First Seen           Last Seen            State        Local Address:Port  Peer Address:Port  PID/Program
+2024-01-02 03:04:05  2024-01-02 03:05:05  ESTABLISHED  10.0.0.1:80         10.0.0.2:4000      12/nginx
+2024-01-02 03:04:15  2024-01-02 03:04:15  TIME_WAIT    10.0.0.1:40000      10.0.0.3:443       -
+`
+	if have := b.String(); have != want {
+		t.Errorf("have:\n%s\nwant:\n%s", have, want)
+	}
+}
+
+func TestParseWhen(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for s, want := range map[string]time.Time{
+		"":                     {},
+		"10m":                  now.Add(-10 * time.Minute),
+		"1h30m":                now.Add(-90 * time.Minute),
+		"2024-01-01T12:00:00Z": time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		"2024-01-01 12:00:00":  time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local),
+	} {
+		have, err := parseWhen(s, now)
+		if err != nil {
+			t.Errorf("%q: %s", s, err)
+			continue
+		}
+		if !have.Equal(want) {
+			t.Errorf("%q: have %s, want %s", s, have, want)
+		}
+	}
+	for _, s := range []string{"yesterday", "-10m", "2024-01-01"} {
+		if _, err := parseWhen(s, now); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"0":     0,
+		"100":   100,
+		"4K":    4 << 10,
+		"500M":  500 << 20,
+		"1G":    1 << 30,
+		"2gb":   2 << 30,
+		"1T":    1 << 40,
+		"1024B": 1024,
+	} {
+		have, err := parseSize(s)
+		if err != nil {
+			t.Errorf("%q: %s", s, err)
+			continue
+		}
+		if have != want {
+			t.Errorf("%q: have %d, want %d", s, have, want)
+		}
+	}
+	for _, s := range []string{"", "M", "-1", "1P", "9999999999T"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
@@ -1,18 +1,298 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alicebob/procspy"
 )
 
+const usage = `usage:
+  lsproc [flags]      established TCP connections
+  lsproc [flags] port [addr:]port
+                      who owns a local port
+  lsproc [flags] pid <pid>
+                      all sockets of a process
+  lsproc record <file>
+                      save what /proc looks like now, for a replay
+  lsproc [flags] replay <file>
+                      all connections from a recording, in any state
+  lsproc [flags] summary [field,...]...
+                      a table with the top groups of connections per list
+                      of fields, by default "process", "raddr", and "state".
+                      Fields are process, pid, container, transport,
+                      lport, rport, raddr[/N[/M]], state, direction, and
+                      netns.
+  lsproc [flags] graph [graph flags] [dot|mermaid|json]
+                      a graph of which processes talk to what, see
+                      lsproc graph -h
+  lsproc serve [flags]
+                      serve the connections over HTTP, see lsproc serve -h
+  lsproc agent -collector <url> [flags]
+                      send snapshots to a collector, see lsproc agent -h
+  lsproc collect [flags]
+                      join the connections of several hosts, see
+                      lsproc collect -h
+  lsproc [flags] history [history flags] [record]
+                      keep snapshots on disk, and see what was connected
+                      when, see lsproc history -h
+
+flags:
+`
+
+var (
+	format    = flag.String("o", "text", "output format: text, json, or ndjson")
+	listening = flag.Bool("l", false, "only listening sockets")
+	all       = flag.Bool("a", false, "sockets in any state")
+	processes = flag.Bool("p", false, "show the process of every socket")
+	numeric   = flag.Bool("n", false, "don't resolve addresses to host names")
+	only4     = flag.Bool("4", false, "only IPv4")
+	only6     = flag.Bool("6", false, "only IPv6")
+	sortOn    = flag.String("s", "", "sort on a column: "+strings.Join(columns, ", "))
+	filter    = flag.String("f", "", "only connections which match a filter expression, such as 'port 443 and process nginx'")
+)
+
 func main() {
-	cs, err := procspy.Connections(true)
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	switch *format {
+	case "text", "json", "ndjson":
+	default:
+		fail(fmt.Sprintf("unknown output format %q", *format))
+	}
+	if err := sortConns(nil, *sortOn); err != nil {
+		fail(err.Error())
+	}
+	if *only4 && *only6 {
+		fail("-4 and -6 together match nothing")
+	}
+	if _, err := procspy.ParseFilter(*filter); err != nil {
+		fail(fmt.Sprintf("invalid filter: %s", err))
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		die(listAll())
+		return
+	}
+	if args[0] == "graph" {
+		die(graphCmd(args[1:]))
+		return
+	}
+	if args[0] == "serve" {
+		serveCmd(args[1:])
+		return
+	}
+	if args[0] == "agent" {
+		agentCmd(args[1:])
+		return
+	}
+	if args[0] == "collect" {
+		collectCmd(args[1:])
+		return
+	}
+	if args[0] == "history" {
+		die(historyCmd(args[1:]))
+		return
+	}
+	if args[0] == "summary" {
+		tables, err := parseTables(args[1:])
+		if err != nil {
+			fail(err.Error())
+		}
+		die(summary(tables))
+		return
+	}
+	if len(args) != 2 {
+		fail("")
+	}
+	switch args[0] {
+	case "port":
+		local, err := parseLocal(args[1])
+		if err != nil {
+			fail(err.Error())
+		}
+		cs, err := procspy.PortOwners(local)
+		if err != nil {
+			die(err)
+		}
+		die(outputSlice(cs))
+	case "pid":
+		pid, err := strconv.ParseUint(args[1], 10, 0)
+		if err != nil {
+			fail(fmt.Sprintf("invalid pid %q", args[1]))
+		}
+		cs, err := procspy.PIDConnections(uint(pid))
+		if err != nil {
+			die(err)
+		}
+		die(outputSlice(cs))
+	case "record":
+		die(record(args[1]))
+	case "replay":
+		die(replay(args[1]))
+	default:
+		fail(fmt.Sprintf("unknown command %q", args[0]))
+	}
+}
+
+func listAll() error {
+	f, err := flagFilter(false)
+	if err != nil {
+		return err
+	}
+	cs, err := procspy.Select(f, *processes)
+	if err != nil {
+		return err
+	}
+	return output(cs, *processes)
+}
+
+func record(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := procspy.Record(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func replay(filename string) error {
+	c, err := procspy.OpenCapture(filename)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fmt.Printf("TCP Connections:\n")
-	for c := cs.Next(); c != nil; c = cs.Next() {
-		fmt.Printf(" - %+v\n", c)
+	defer c.Close()
+	f, err := flagFilter(true)
+	if err != nil {
+		return err
+	}
+	cs, err := procspy.NewScanner(c).Select(f, true)
+	if err != nil {
+		return err
+	}
+	return output(cs, *processes)
+}
+
+// flagFilter combines -f, -l, -a, -4, and -6 in a filter. anyState is what's
+// wanted without -l and -a.
+func flagFilter(anyState bool) (*procspy.Filter, error) {
+	var exprs []string
+	if *filter != "" {
+		exprs = append(exprs, "("+*filter+")")
+	}
+	switch {
+	case *listening:
+		exprs = append(exprs, "listening")
+	case !*all && !anyState:
+		exprs = append(exprs, "state established")
+	}
+	switch {
+	case *only4:
+		exprs = append(exprs, "net 0.0.0.0/0")
+	case *only6:
+		exprs = append(exprs, "net ::/0")
+	}
+	return procspy.ParseFilter(strings.Join(exprs, " and "))
+}
+
+// output writes the connections in the format of the -o
+// flag. json is a single snapshot, ndjson a connection per line, and text a
+// table.
+func output(it procspy.ConnIter, showProcs bool) error {
+	return outputAt(it, showProcs, time.Now())
+}
+
+// outputAt is output() for connections of time t.
+func outputAt(it procspy.ConnIter, showProcs bool, t time.Time) error {
+	// A snapshot has copies, the iterator reuses its connections.
+	snap, err := procspy.NewSnapshot(it, t)
+	if err != nil {
+		return err
+	}
+	cs := snap.Connections
+	if err := sortConns(cs, *sortOn); err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		s, err := procspy.NewSnapshot(procspy.SliceIter(cs), t)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case "ndjson":
+		enc := json.NewEncoder(os.Stdout)
+		for i := range cs {
+			if err := enc.Encode(&cs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		var resolve func(netip.Addr) string
+		if !*numeric {
+			resolve = resolver()
+		}
+		return table(os.Stdout, cs, showProcs, resolve)
+	}
+}
+
+// outputSlice writes the result of a lookup, with the flags applied.
+func outputSlice(cs []procspy.Connection) error {
+	f, err := flagFilter(true)
+	if err != nil {
+		return err
+	}
+	return output(f.Iter(procspy.SliceIter(cs)), true)
+}
+
+// parseLocal understands "8080", ":8080", "127.0.0.1:8080", and "[::1]:8080".
+// Without an address every local address matches.
+func parseLocal(s string) (netip.AddrPort, error) {
+	if !strings.Contains(s, ":") || strings.HasPrefix(s, ":") {
+		port, err := strconv.ParseUint(strings.TrimPrefix(s, ":"), 10, 16)
+		if err != nil {
+			return netip.AddrPort{}, fmt.Errorf("invalid port %q", s)
+		}
+		return netip.AddrPortFrom(netip.IPv6Unspecified(), uint16(port)), nil
+	}
+	ap, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	return ap, nil
+}
+
+// fail is for usage errors.
+func fail(msg string) {
+	if msg != "" {
+		fmt.Fprintf(os.Stderr, "lsproc: %s\n", msg)
+	}
+	flag.Usage()
+	os.Exit(2)
+}
+
+// die exits with an error, if there is one.
+func die(err error) {
+	if err == nil {
+		return
 	}
+	fmt.Fprintf(os.Stderr, "lsproc: %s\n", err)
+	os.Exit(1)
 }
@@ -0,0 +1,364 @@
+package main
+
+// lsproc serve: an HTTP API.
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alicebob/procspy"
+)
+
+const serveUsage = `usage: lsproc serve [flags]
+
+Serves JSON over HTTP:
+  GET /v1/snapshot[?filter=...]     a snapshot, see procspy.Snapshot
+  GET /v1/connections[?filter=...]  connections in any state
+  GET /v1/pid/{pid}                 the sockets of a process
+  GET /v1/port/{port}[?addr=...]    who owns a local port, in our network
+                                    namespace
+  GET /v1/watch[?filter=...]        Server-Sent Events: a "snapshot" event,
+                                    then "added", "removed", and "changed"
+                                    events with the differences
+Filters are as with -f.
+
+flags:
+`
+
+func serveCmd(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, serveUsage)
+		fs.PrintDefaults()
+	}
+	var (
+		listen    = fs.String("listen", "127.0.0.1:9667", "TCP address, or unix:<path> for a Unix socket")
+		mode      = fs.String("mode", "0660", "permissions of the Unix socket")
+		group     = fs.String("group", "", "group of the Unix socket")
+		tokenFile = fs.String("token-file", "", "file with a token, which clients have to send as \"Authorization: Bearer <token>\"")
+		interval  = fs.Duration("interval", 2*time.Second, "how often /v1/watch scans")
+	)
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	token, err := readToken(*tokenFile)
+	if err != nil {
+		die(err)
+	}
+
+	var l net.Listener
+	if path, ok := strings.CutPrefix(*listen, "unix:"); ok {
+		perm, err := strconv.ParseUint(*mode, 8, 32)
+		if err != nil {
+			fail(fmt.Sprintf("invalid mode %q", *mode))
+		}
+		l, err = listenUnix(path, os.FileMode(perm), *group)
+		if err != nil {
+			die(err)
+		}
+	} else if l, err = net.Listen("tcp", *listen); err != nil {
+		die(err)
+	}
+	s := &server{
+		scanner:        &procspy.Scanner{},
+		pidConnections: procspy.PIDConnections,
+		portOwners:     procspy.PortOwners,
+		token:          token,
+		interval:       *interval,
+	}
+	die(http.Serve(l, s.handler()))
+}
+
+// listenUnix listens on a Unix socket, which only the owner and the group can
+// use with the default mode. A stale socket is removed. The socket is made
+// under a umask which only lets the owner in, until the group and the mode
+// are set. The umask is of the whole process, so call this before anything
+// else makes files.
+func listenUnix(path string, mode os.FileMode, group string) (net.Listener, error) {
+	gid := -1
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	old := syscall.Umask(0177)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(old)
+	if err != nil {
+		return nil, err
+	}
+	if gid != -1 {
+		if err := os.Chown(path, -1, gid); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+type server struct {
+	scanner *procspy.Scanner
+	// for /v1/pid and /v1/port, such as procspy.PIDConnections
+	pidConnections func(uint) ([]procspy.Connection, error)
+	portOwners     func(netip.AddrPort) ([]procspy.Connection, error)
+	token          string // "" is no auth
+	interval       time.Duration
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/snapshot", method("GET", s.snapshot))
+	mux.HandleFunc("/v1/connections", method("GET", s.connections))
+	mux.HandleFunc("/v1/pid/", method("GET", s.pid))
+	mux.HandleFunc("/v1/port/", method("GET", s.port))
+	mux.HandleFunc("/v1/watch", method("GET", s.watch))
+	return auth(s.token, mux)
+}
+
+// method only lets requests with method m through. The routes don't use
+// method patterns, since those depend on the GODEBUG of the build.
+func method(m string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m {
+			w.Header().Set("Allow", m)
+			httpError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// readToken reads a token from a file. No file is no token.
+func readToken(filename string) (string, error) {
+	if filename == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("empty token in %s", filename)
+	}
+	return token, nil
+}
+
+// auth checks for "Authorization: Bearer <token>". An empty token is no auth.
+func auth(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="lsproc"`)
+			httpError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// badRequest is an error in what the client sent.
+type badRequest struct{ error }
+
+// scan takes a snapshot of the connections which match expr.
+func (s *server) scan(expr string) (*procspy.Snapshot, error) {
+	f, err := procspy.ParseFilter(expr)
+	if err != nil {
+		return nil, badRequest{fmt.Errorf("invalid filter: %w", err)}
+	}
+	it, err := s.scanner.Select(f, true)
+	if err != nil {
+		return nil, err
+	}
+	return procspy.NewSnapshot(it, time.Now())
+}
+
+func (s *server) snapshot(w http.ResponseWriter, r *http.Request) {
+	snap, err := s.scan(r.URL.Query().Get("filter"))
+	if err != nil {
+		scanError(w, err)
+		return
+	}
+	writeJSON(w, snap)
+}
+
+func (s *server) connections(w http.ResponseWriter, r *http.Request) {
+	s.list(w, r.URL.Query().Get("filter"))
+}
+
+func (s *server) pid(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/v1/pid/")
+	pid, err := strconv.ParseUint(p, 10, 0)
+	if err != nil || pid == 0 {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("invalid pid %q", p))
+		return
+	}
+	cs, err := s.pidConnections(uint(pid))
+	if err != nil {
+		if errors.Is(err, procspy.ErrNoProcess) {
+			httpError(w, http.StatusNotFound, err)
+			return
+		}
+		scanError(w, err)
+		return
+	}
+	writeConnections(w, cs)
+}
+
+func (s *server) port(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/v1/port/")
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("invalid port %q", p))
+		return
+	}
+	// Unspecified matches every address.
+	addr := netip.IPv6Unspecified()
+	if a := r.URL.Query().Get("addr"); a != "" {
+		addr, err = netip.ParseAddr(a)
+		if err != nil || addr.Zone() != "" {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("invalid address %q", a))
+			return
+		}
+	}
+	cs, err := s.portOwners(netip.AddrPortFrom(addr, uint16(port)))
+	if err != nil {
+		scanError(w, err)
+		return
+	}
+	writeConnections(w, cs)
+}
+
+func (s *server) list(w http.ResponseWriter, expr string) {
+	snap, err := s.scan(expr)
+	if err != nil {
+		scanError(w, err)
+		return
+	}
+	writeConnections(w, snap.Connections)
+}
+
+// writeConnections writes a JSON list, which is [] if there are none.
+func writeConnections(w http.ResponseWriter, cs []procspy.Connection) {
+	if cs == nil {
+		cs = []procspy.Connection{}
+	}
+	writeJSON(w, cs)
+}
+
+// change is how a procspy.ConnectionChange looks in a watch event.
+type change struct {
+	Old procspy.Connection `json:"old"`
+	New procspy.Connection `json:"new"`
+}
+
+func (s *server) watch(w http.ResponseWriter, r *http.Request) {
+	expr := r.URL.Query().Get("filter")
+	snap, err := s.scan(expr)
+	if err != nil {
+		scanError(w, err)
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := sendEvent(w, "snapshot", snap); err != nil {
+		return
+	}
+	rc.Flush()
+
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-t.C:
+		}
+		next, err := s.scan(expr)
+		if err != nil {
+			if sendEvent(w, "error", map[string]string{"error": err.Error()}) != nil {
+				return
+			}
+			rc.Flush()
+			continue
+		}
+		d := procspy.Diff(snap, next)
+		snap = next
+		for _, c := range d.Added {
+			err = errors.Join(err, sendEvent(w, "added", c))
+		}
+		for _, c := range d.Removed {
+			err = errors.Join(err, sendEvent(w, "removed", c))
+		}
+		for _, c := range d.Changed {
+			err = errors.Join(err, sendEvent(w, "changed", change{Old: c.Old, New: c.New}))
+		}
+		if err != nil {
+			return
+		}
+		rc.Flush()
+	}
+}
+
+// sendEvent writes a Server-Sent Event. JSON has no newlines, so the data
+// fits on one line.
+func sendEvent(w http.ResponseWriter, event string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write: %s", err)
+	}
+}
+
+func scanError(w http.ResponseWriter, err error) {
+	if errors.As(err, &badRequest{}) {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("scan: %s", err)
+	httpError(w, http.StatusInternalServerError, err)
+}
+
+func httpError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/alicebob/procspy"
+)
+
+// switchBackend gives different connections after a swap.
+type switchBackend struct {
+	mu sync.Mutex
+	cs procspy.Fixtures
+}
+
+func (b *switchBackend) Connections(processes, all bool) (procspy.ConnIter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.cs.Connections(processes, all)
+}
+
+func (b *switchBackend) set(cs procspy.Fixtures) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cs = cs
+}
+
+func get(t *testing.T, url, token string, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode
+}
+
+func TestServe(t *testing.T) {
+	var (
+		nginx  = procspy.Proc{PID: 42, Name: "nginx"}
+		listen = conn(procspy.StateListen, "0.0.0.0:443", "0.0.0.0:0", 42, "nginx")
+		estab  = conn(procspy.StateEstablished, "10.0.0.1:443", "10.0.0.2:50000", 42, "nginx")
+		curl   = conn(procspy.StateEstablished, "10.0.0.1:40000", "10.0.0.3:80", 43, "curl")
+		b      = &switchBackend{cs: procspy.Fixtures{listen, estab}}
+		s      = &server{scanner: procspy.NewScanner(b), token: "sekrit", interval: 10 * time.Millisecond}
+		ts     = httptest.NewServer(s.handler())
+		owners netip.AddrPort // asked for
+	)
+	defer ts.Close()
+	s.pidConnections = func(pid uint) ([]procspy.Connection, error) {
+		if pid != 42 {
+			return nil, procspy.ErrNoProcess
+		}
+		return []procspy.Connection{listen, estab}, nil
+	}
+	s.portOwners = func(local netip.AddrPort) ([]procspy.Connection, error) {
+		owners = local
+		if local.Port() != 443 {
+			return nil, nil
+		}
+		return []procspy.Connection{listen, estab}, nil
+	}
+
+	var e map[string]string
+	if status := get(t, ts.URL+"/v1/connections", "", &e); status != http.StatusUnauthorized {
+		t.Errorf("have %d, want 401", status)
+	}
+	if status := get(t, ts.URL+"/v1/connections", "wrong", &e); status != http.StatusUnauthorized {
+		t.Errorf("have %d, want 401", status)
+	}
+
+	var cs []procspy.Connection
+	if status := get(t, ts.URL+"/v1/connections?filter=state+established", "sekrit", &cs); status != http.StatusOK {
+		t.Fatalf("have %d", status)
+	}
+	if len(cs) != 1 || cs[0].Key() != estab.Key() || cs[0].Proc != nginx {
+		t.Errorf("have %+v", cs)
+	}
+
+	if status := get(t, ts.URL+"/v1/connections?filter=port", "sekrit", &e); status != http.StatusBadRequest || !strings.Contains(e["error"], "invalid filter") {
+		t.Errorf("have %d %v", status, e)
+	}
+
+	var snap procspy.Snapshot
+	if status := get(t, ts.URL+"/v1/snapshot", "sekrit", &snap); status != http.StatusOK || len(snap.Connections) != 2 {
+		t.Errorf("have %d %+v", status, snap)
+	}
+
+	cs = nil
+	if status := get(t, ts.URL+"/v1/pid/42", "sekrit", &cs); status != http.StatusOK || len(cs) != 2 {
+		t.Errorf("have %d %d", status, len(cs))
+	}
+	if status := get(t, ts.URL+"/v1/pid/43", "sekrit", &e); status != http.StatusNotFound {
+		t.Errorf("have %d, want 404", status)
+	}
+	for url, want := range map[string]struct {
+		owners string
+		n      int
+	}{
+		"/v1/port/443":               {"[::]:443", 2},
+		"/v1/port/443?addr=10.0.0.1": {"10.0.0.1:443", 2},
+		"/v1/port/80":                {"[::]:80", 0},
+	} {
+		cs = nil
+		if status := get(t, ts.URL+url, "sekrit", &cs); status != http.StatusOK || len(cs) != want.n {
+			t.Errorf("%s: have %d %d, want %d", url, status, len(cs), want.n)
+		}
+		if have := owners.String(); have != want.owners {
+			t.Errorf("%s: have %s, want %s", url, have, want.owners)
+		}
+	}
+	for _, url := range []string{"/v1/pid/x", "/v1/port/70000", "/v1/port/80?addr=nosuch"} {
+		if status := get(t, ts.URL+url, "sekrit", &e); status != http.StatusBadRequest {
+			t.Errorf("%s: have %d, want 400", url, status)
+		}
+	}
+
+	// watch
+	req, _ := http.NewRequest("GET", ts.URL+"/v1/watch?filter=not+listening", nil)
+	req.Header.Set("Authorization", "Bearer sekrit")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if have, want := res.Header.Get("Content-Type"), "text/event-stream"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+	r := bufio.NewReader(res.Body)
+	event := func() (string, procspy.Connection) {
+		t.Helper()
+		var name, data string
+		for {
+			l, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			l = strings.TrimSuffix(l, "\n")
+			switch {
+			case l == "":
+				var c procspy.Connection
+				if name != "snapshot" {
+					if err := json.Unmarshal([]byte(data), &c); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return name, c
+			case strings.HasPrefix(l, "event: "):
+				name = l[len("event: "):]
+			case strings.HasPrefix(l, "data: "):
+				data = l[len("data: "):]
+			}
+		}
+	}
+	if name, _ := event(); name != "snapshot" {
+		t.Fatalf("have %q, want snapshot", name)
+	}
+	b.set(procspy.Fixtures{listen, curl})
+	got := map[string]procspy.Key{}
+	for len(got) < 2 {
+		name, c := event()
+		got[name] = c.Key()
+	}
+	if got["added"] != curl.Key() || got["removed"] != estab.Key() {
+		t.Errorf("have %+v", got)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lsproc.sock")
+	umask := syscall.Umask(022)
+	defer syscall.Umask(umask)
+	for i, mode := range []os.FileMode{0600, 0660} {
+		// The second time there is a stale socket.
+		l, err := listenUnix(path, mode, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := fi.Mode().Perm(), mode; have != want {
+			t.Errorf("have %v, want %v", have, want)
+		}
+		if have, want := syscall.Umask(022), 022; have != want {
+			t.Errorf("umask %o, want %o", have, want)
+		}
+		if i == 0 {
+			// Like a crash: no cleanup.
+			l.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alicebob/procspy"
+)
+
+var top = flag.Int("top", 10, "rows per summary table, 0 for all of them")
+
+// defaultTables are the summary tables without arguments.
+var defaultTables = []string{"process", "raddr", "state"}
+
+// parseTables parses the arguments of `lsproc summary`, a list of fields per
+// table.
+func parseTables(args []string) ([][]procspy.GroupField, error) {
+	if len(args) == 0 {
+		args = defaultTables
+	}
+	var tables [][]procspy.GroupField
+	for _, a := range args {
+		fields, err := procspy.ParseGroupFields(a)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, fields)
+	}
+	return tables, nil
+}
+
+// jsonTable is how a summary table looks in JSON.
+type jsonTable struct {
+	Fields []string    `json:"fields"`
+	Groups []jsonGroup `json:"groups"`
+}
+
+type jsonGroup struct {
+	Values []string `json:"values"`
+	Count  int      `json:"count"`
+	RecvQ  uint64   `json:"recv_q"`
+	SendQ  uint64   `json:"send_q"`
+}
+
+func summary(tables [][]procspy.GroupField) error {
+	f, err := flagFilter(false)
+	if err != nil {
+		return err
+	}
+	processes := *processes
+	for _, fields := range tables {
+		for _, field := range fields {
+			processes = processes || field.Processes()
+		}
+	}
+	it, err := procspy.Select(f, processes)
+	if err != nil {
+		return err
+	}
+	// Every table goes over the same connections.
+	snap, err := procspy.NewSnapshot(it, time.Now())
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	var js []jsonTable
+	for i, fields := range tables {
+		groups, err := procspy.GroupBy(procspy.SliceIter(snap.Connections), fields...)
+		if err != nil {
+			return err
+		}
+		if *top > 0 && len(groups) > *top {
+			groups = groups[:*top]
+		}
+		switch *format {
+		case "json", "ndjson":
+			t := jsonTable{Groups: []jsonGroup{}}
+			for _, field := range fields {
+				t.Fields = append(t.Fields, field.String())
+			}
+			for _, g := range groups {
+				t.Groups = append(t.Groups, jsonGroup(g))
+			}
+			if *format == "ndjson" {
+				if err := enc.Encode(t); err != nil {
+					return err
+				}
+				continue
+			}
+			js = append(js, t)
+		default:
+			if i > 0 {
+				fmt.Println()
+			}
+			if err := groupTable(os.Stdout, fields, groups); err != nil {
+				return err
+			}
+		}
+	}
+	if *format == "json" {
+		enc.SetIndent("", "  ")
+		return enc.Encode(js)
+	}
+	return nil
+}
+
+// groupTable writes a summary table.
+func groupTable(w io.Writer, fields []procspy.GroupField, groups []procspy.Group) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	var header []string
+	for _, f := range fields {
+		header = append(header, strings.ToUpper(f.String()))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\tCOUNT\tRECV-Q\tSEND-Q")
+	for _, g := range groups {
+		values := make([]string, len(g.Values))
+		for i, v := range g.Values {
+			if v == "" {
+				v = "-"
+			}
+			values[i] = v
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", strings.Join(values, "\t"), g.Count, g.RecvQ, g.SendQ)
+	}
+	return tw.Flush()
+}
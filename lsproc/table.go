@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alicebob/procspy"
+)
+
+// columns are the columns of the table, and what -s sorts on.
+var columns = []string{"state", "recvq", "sendq", "local", "peer", "process", "pid", "netns"}
+
+// sortConns sorts on a column. Ties keep their order.
+func sortConns(cs []procspy.Connection, column string) error {
+	var less func(a, b *procspy.Connection) bool
+	switch column {
+	case "":
+		return nil
+	case "state":
+		less = func(a, b *procspy.Connection) bool { return a.State < b.State }
+	case "recvq":
+		less = func(a, b *procspy.Connection) bool { return a.RecvQ < b.RecvQ }
+	case "sendq":
+		less = func(a, b *procspy.Connection) bool { return a.SendQ < b.SendQ }
+	case "local":
+		less = func(a, b *procspy.Connection) bool { return lessAddrPort(a.Local(), b.Local()) }
+	case "peer":
+		less = func(a, b *procspy.Connection) bool { return lessAddrPort(a.Remote(), b.Remote()) }
+	case "process":
+		less = func(a, b *procspy.Connection) bool { return a.Name < b.Name }
+	case "pid":
+		less = func(a, b *procspy.Connection) bool { return a.PID < b.PID }
+	case "netns":
+		less = func(a, b *procspy.Connection) bool { return a.NetNS < b.NetNS }
+	default:
+		return fmt.Errorf("can't sort on %q, use one of %s", column, strings.Join(columns, ", "))
+	}
+	sort.SliceStable(cs, func(i, j int) bool { return less(&cs[i], &cs[j]) })
+	return nil
+}
+
+func lessAddrPort(a, b netip.AddrPort) bool {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c < 0
+	}
+	return a.Port() < b.Port()
+}
+
+// table writes the connections like `ss -tanp` does. resolve gives a name for
+// an address, or "" to print it as a number.
+func table(w io.Writer, cs []procspy.Connection, processes bool, resolve func(netip.Addr) string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	header := "State\tRecv-Q\tSend-Q\tLocal Address:Port\tPeer Address:Port"
+	if processes {
+		header += "\tPID/Program"
+	}
+	fmt.Fprintln(tw, header)
+	for i := range cs {
+		c := &cs[i]
+		line := fmt.Sprintf("%s\t%d\t%d\t%s\t%s", c.State, c.RecvQ, c.SendQ, hostPort(c.Local(), resolve), hostPort(c.Remote(), resolve))
+		if processes {
+			p := "-"
+			if c.PID != 0 {
+				p = strconv.FormatUint(uint64(c.PID), 10) + "/" + c.Name
+			}
+			line += "\t" + p
+		}
+		fmt.Fprintln(tw, line)
+	}
+	return tw.Flush()
+}
+
+// hostPort formats an address like ss does: port 0 is "*", and IPv6
+// addresses are in brackets.
+func hostPort(ap netip.AddrPort, resolve func(netip.Addr) string) string {
+	port := "*"
+	if ap.Port() != 0 {
+		port = strconv.Itoa(int(ap.Port()))
+	}
+	host := ""
+	if resolve != nil && !ap.Addr().IsUnspecified() {
+		host = resolve(ap.Addr())
+	}
+	if host == "" {
+		host = ap.Addr().String()
+		if ap.Addr().Is6() {
+			host = "[" + host + "]"
+		}
+	}
+	return host + ":" + port
+}
+
+// resolver does reverse DNS lookups, once per address.
+func resolver() func(netip.Addr) string {
+	cache := map[netip.Addr]string{}
+	return func(a netip.Addr) string {
+		if name, ok := cache[a]; ok {
+			return name
+		}
+		var name string
+		if names, err := net.LookupAddr(a.WithZone("").String()); err == nil && len(names) > 0 {
+			name = strings.TrimSuffix(names[0], ".")
+		}
+		cache[a] = name
+		return name
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/alicebob/procspy"
+)
+
+func conn(state procspy.State, local, remote string, pid uint, name string) procspy.Connection {
+	l, r := netip.MustParseAddrPort(local), netip.MustParseAddrPort(remote)
+	return procspy.Connection{
+		Transport:     "tcp",
+		LocalAddress:  net.IP(l.Addr().AsSlice()),
+		LocalPort:     l.Port(),
+		RemoteAddress: net.IP(r.Addr().AsSlice()),
+		RemotePort:    r.Port(),
+		State:         state,
+		Proc:          procspy.Proc{PID: pid, Name: name},
+	}
+}
+
+func TestTable(t *testing.T) {
+	cs := []procspy.Connection{
+		conn(procspy.StateListen, "0.0.0.0:80", "0.0.0.0:0", 12, "nginx"),
+		conn(procspy.StateEstablished, "[::1]:4000", "[::1]:5432", 0, ""),
+		conn(procspy.StateEstablished, "10.0.0.1:80", "10.0.0.2:4000", 12, "nginx"),
+	}
+	cs[0].SendQ = 128
+	if err := sortConns(cs, "local"); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := table(&b, cs, true, func(a netip.Addr) string {
+		if a == netip.MustParseAddr("10.0.0.2") {
+			return "db"
+		}
+		return ""
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := `State        Recv-Q  Send-Q  Local Address:Port  Peer Address:Port  PID/Program
+LISTEN       0       128     0.0.0.0:80          0.0.0.0:*          12/nginx
+ESTABLISHED  0       0       10.0.0.1:80         db:4000            12/nginx
+ESTABLISHED  0       0       [::1]:4000          [::1]:5432         -
+`
+	if have := b.String(); have != want {
+		t.Errorf("have:\n%s\nwant:\n%s", have, want)
+	}
+
+	if err := sortConns(cs, "nosuch"); err == nil {
+		t.Errorf("no error for an unknown column")
+	}
+}
+
+func TestGroupTable(t *testing.T) {
+	fields, err := procspy.ParseGroupFields("process,raddr/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := groupTable(&b, fields, []procspy.Group{
+		{Values: []string{"nginx", "10.0.0.0/24"}, Count: 12, RecvQ: 3, SendQ: 1448},
+		{Values: []string{"", "10.0.1.0/24"}, Count: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := `PROCESS  RADDR/24     COUNT  RECV-Q  SEND-Q
+nginx    10.0.0.0/24  12     3       1448
+-        10.0.1.0/24  1      0       0
+`
+	if have := b.String(); have != want {
+		t.Errorf("have:\n%s\nwant:\n%s", have, want)
+	}
+}
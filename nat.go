@@ -0,0 +1,96 @@
+package procspy
+
+// NAT information from conntrack.
+
+import (
+	"bufio"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// NATEntry is a TCP connection which is translated. The client sees it as
+// OrigSrc -> OrigDst, the server as ReplySrc -> ReplyDst.
+type NATEntry struct {
+	OrigSrc  netip.AddrPort `json:"orig_src"`
+	OrigDst  netip.AddrPort `json:"orig_dst"`
+	ReplySrc netip.AddrPort `json:"reply_src"`
+	ReplyDst netip.AddrPort `json:"reply_dst"`
+}
+
+// parseConntrack reads the translated TCP connections from a
+// /proc/net/nf_conntrack file:
+//
+//	ipv4 2 tcp 6 431999 ESTABLISHED src=10.0.0.2 dst=10.0.0.1 sport=50000 dport=80 src=172.17.0.2 dst=10.0.0.2 sport=8080 dport=50000 [ASSURED] mark=0 use=1
+//
+// The first src, dst, sport, and dport are the original direction, the
+// second are the reply direction. Lines it doesn't understand are skipped.
+func parseConntrack(r io.Reader) ([]NATEntry, error) {
+	var res []NATEntry
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		var (
+			fields = strings.Fields(s.Text())
+			tcp    bool
+			// src, dst, sport, dport; twice
+			tuple [2][4]string
+			n     [2]int
+		)
+		for _, f := range fields {
+			if f == "tcp" {
+				tcp = true
+				continue
+			}
+			k, v, ok := strings.Cut(f, "=")
+			if !ok {
+				continue
+			}
+			i := -1
+			switch k {
+			case "src":
+				i = 0
+			case "dst":
+				i = 1
+			case "sport":
+				i = 2
+			case "dport":
+				i = 3
+			}
+			if i < 0 {
+				continue
+			}
+			dir := 0
+			if tuple[0][i] != "" {
+				dir = 1
+			}
+			if tuple[dir][i] == "" {
+				tuple[dir][i] = v
+				n[dir]++
+			}
+		}
+		if !tcp || n[0] != 4 || n[1] != 4 {
+			continue
+		}
+		var (
+			e  NATEntry
+			ok = true
+		)
+		for i, ap := range []*netip.AddrPort{&e.OrigSrc, &e.OrigDst, &e.ReplySrc, &e.ReplyDst} {
+			t := tuple[i/2]
+			addr, err := netip.ParseAddr(t[i%2])
+			port, perr := strconv.ParseUint(t[2+i%2], 10, 16)
+			if err != nil || perr != nil {
+				ok = false
+				break
+			}
+			*ap = netip.AddrPortFrom(addr.Unmap(), uint16(port))
+		}
+		if !ok || (e.ReplySrc == e.OrigDst && e.ReplyDst == e.OrigSrc) {
+			// Not translated.
+			continue
+		}
+		res = append(res, e)
+	}
+	return res, s.Err()
+}
@@ -0,0 +1,21 @@
+package procspy
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// ReadNAT gives the translated TCP connections of our network namespace, from
+// net/nf_conntrack in the proc directory. You need to be root. Without
+// conntrack it returns nothing, and no error.
+func ReadNAT() ([]NATEntry, error) {
+	f, err := defaultFiles.open("net/nf_conntrack")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return parseConntrack(f)
+}
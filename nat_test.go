@@ -0,0 +1,40 @@
+package procspy
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConntrack(t *testing.T) {
+	in := `ipv4     2 tcp      6 431999 ESTABLISHED src=10.0.0.2 dst=10.0.0.1 sport=50000 dport=80 src=172.17.0.2 dst=10.0.0.2 sport=8080 dport=50000 [ASSURED] mark=0 zone=0 use=2
+ipv4     2 tcp      6 431999 ESTABLISHED src=10.0.0.1 dst=10.0.0.3 sport=40000 dport=22 src=10.0.0.3 dst=10.0.0.1 sport=22 dport=40000 [ASSURED] mark=0 zone=0 use=2
+ipv4     2 udp      17 29 src=10.0.0.2 dst=10.0.0.1 sport=53000 dport=53 src=172.17.0.3 dst=10.0.0.2 sport=53 dport=53000 mark=0 zone=0 use=2
+ipv6     10 tcp      6 119 TIME_WAIT src=2001:db8::2 dst=2001:db8::1 sport=50001 dport=443 src=fd00::2 dst=2001:db8::2 sport=8443 dport=50001 [ASSURED] mark=0 zone=0 use=2
+ipv4     2 tcp      6 10 SYN_SENT src=10.0.0.2 dst=10.0.0.1 sport=50002 dport=80 [UNREPLIED] mark=0 zone=0 use=2
+garbage
+`
+	have, err := parseConntrack(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap := netip.MustParseAddrPort
+	want := []NATEntry{
+		{
+			OrigSrc:  ap("10.0.0.2:50000"),
+			OrigDst:  ap("10.0.0.1:80"),
+			ReplySrc: ap("172.17.0.2:8080"),
+			ReplyDst: ap("10.0.0.2:50000"),
+		},
+		{
+			OrigSrc:  ap("[2001:db8::2]:50001"),
+			OrigDst:  ap("[2001:db8::1]:443"),
+			ReplySrc: ap("[fd00::2]:8443"),
+			ReplyDst: ap("[2001:db8::2]:50001"),
+		},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", have, want)
+	}
+}
@@ -8,9 +8,24 @@ import (
 	"strings"
 )
 
+// netstatArgs are the arguments for netstat on Darwin. Without -a netstat
+// leaves out listening sockets.
+func netstatArgs(all bool) []string {
+	args := []string{
+		"-n",        // no number resolving
+		"-W",        // Wide output
+		"-p", "tcp", // only TCP
+	}
+	if all {
+		args = append(args, "-a")
+	}
+	return args
+}
+
 // parseDarwinNetstat parses netstat output. (Linux has ip:port, darwin
-// ip.port. The 'Proto' column value also differs.)
-func parseDarwinNetstat(out string) []Connection {
+// ip.port. The 'Proto' column value also differs.) If all is false only
+// established connections are returned.
+func parseDarwinNetstat(out string, all bool) []Connection {
 	//
 	//  Active Internet connections
 	//  Proto Recv-Q Send-Q  Local Address          Foreign Address        (state)
@@ -29,12 +44,16 @@ func parseDarwinNetstat(out string) []Connection {
 			continue
 		}
 
-		if fields[5] != "ESTABLISHED" {
+		state, ok := darwinStates[fields[5]]
+		if !ok || (!all && state != StateEstablished) {
 			continue
 		}
 
 		t := Connection{
 			Transport: "tcp",
+			State:     state,
+			RecvQ:     parseQueue(fields[1]),
+			SendQ:     parseQueue(fields[2]),
 		}
 
 		// Format is <ip>.<port>
@@ -48,9 +67,9 @@ func parseDarwinNetstat(out string) []Connection {
 			localPort    = locals[len(locals)-1]
 		)
 
-		t.LocalAddress = net.ParseIP(localAddress)
+		t.LocalAddress, t.localZone = parseZonedIP(localAddress, fields[0])
 
-		p, err := strconv.Atoi(localPort)
+		p, err := parsePort(localPort)
 		if err != nil {
 			return nil
 		}
@@ -67,9 +86,9 @@ func parseDarwinNetstat(out string) []Connection {
 			remotePort    = remotes[len(remotes)-1]
 		)
 
-		t.RemoteAddress = net.ParseIP(remoteAddress)
+		t.RemoteAddress, t.remoteZone = parseZonedIP(remoteAddress, fields[0])
 
-		p, err = strconv.Atoi(remotePort)
+		p, err = parsePort(remotePort)
 		if err != nil {
 			return nil
 		}
@@ -81,3 +100,33 @@ func parseDarwinNetstat(out string) []Connection {
 
 	return res
 }
+
+// parseZonedIP parses an IP with an optional zone, such as 'fe80::1%lo0'. '*'
+// is the unspecified address of the protocol.
+func parseZonedIP(s, proto string) (net.IP, string) {
+	if s == "*" {
+		if proto == "tcp4" {
+			return net.IPv4zero, ""
+		}
+		return net.IPv6unspecified, ""
+	}
+	var zone string
+	if i := strings.IndexByte(s, '%'); i != -1 {
+		s, zone = s[:i], s[i+1:]
+	}
+	return net.ParseIP(s), zone
+}
+
+// parsePort parses a port. '*' is port 0.
+func parsePort(s string) (int, error) {
+	if s == "*" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// parseQueue parses a Recv-Q or Send-Q column. It's 0 if it's not a number.
+func parseQueue(s string) uint32 {
+	n, _ := strconv.ParseUint(s, 10, 32)
+	return uint32(n)
+}
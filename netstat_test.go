@@ -2,6 +2,7 @@ package procspy
 
 import (
 	"net"
+	"net/netip"
 	"reflect"
 	"testing"
 )
@@ -10,14 +11,15 @@ func TestNetstatDarwin(t *testing.T) {
 	testString := `Active Internet connections
 Proto Recv-Q Send-Q  Local Address          Foreign Address        (state)
 tcp4       0      0  10.0.1.6.58287         1.2.3.4.443      		ESTABLISHED
-tcp4       0      0  10.0.1.6.58279         2.3.4.5.80         		ESTABLISHED
+tcp4      12    304  10.0.1.6.58279         2.3.4.5.80         		ESTABLISHED
 tcp4       0      0  10.0.1.6.58276         44.55.66.77.443    		ESTABLISHED
 tcp4       0      0  10.0.1.6.1         	4.0.4.0.443    			GONE
 `
-	res := parseDarwinNetstat(testString)
+	res := parseDarwinNetstat(testString, false)
 	expected := []Connection{
 		{
 			Transport:     "tcp",
+			State:         StateEstablished,
 			LocalAddress:  net.ParseIP("10.0.1.6"),
 			LocalPort:     58287,
 			RemoteAddress: net.ParseIP("1.2.3.4"),
@@ -25,13 +27,17 @@ tcp4       0      0  10.0.1.6.1         	4.0.4.0.443    			GONE
 		},
 		{
 			Transport:     "tcp",
+			State:         StateEstablished,
 			LocalAddress:  net.ParseIP("10.0.1.6"),
 			LocalPort:     58279,
 			RemoteAddress: net.ParseIP("2.3.4.5"),
 			RemotePort:    80,
+			RecvQ:         12,
+			SendQ:         304,
 		},
 		{
 			Transport:     "tcp",
+			State:         StateEstablished,
 			LocalAddress:  net.ParseIP("10.0.1.6"),
 			LocalPort:     58276,
 			RemoteAddress: net.ParseIP("44.55.66.77"),
@@ -56,3 +62,69 @@ tcp4       0      0  10.0.1.6.1         	4.0.4.0.443    			GONE
 	}
 
 }
+
+func TestNetstatDarwinIPv6(t *testing.T) {
+	testString := `Active Internet connections
+Proto Recv-Q Send-Q  Local Address          Foreign Address        (state)
+tcp6       0      0  fe80::1%lo0.6600       fe80::1%lo0.50992      ESTABLISHED
+tcp6       0      0  ::ffff:10.0.1.6.58287  ::ffff:1.2.3.4.443     ESTABLISHED
+`
+	res := parseDarwinNetstat(testString, false)
+	if have, want := len(res), 2; have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	for i, want := range []Key{
+		{
+			Transport: "tcp",
+			Local:     netip.MustParseAddrPort("[fe80::1%lo0]:6600"),
+			Remote:    netip.MustParseAddrPort("[fe80::1%lo0]:50992"),
+		},
+		{
+			Transport: "tcp",
+			Local:     netip.MustParseAddrPort("10.0.1.6:58287"),
+			Remote:    netip.MustParseAddrPort("1.2.3.4:443"),
+		},
+	} {
+		if have := res[i].Key(); have != want {
+			t.Errorf("%d: have %v, want %v", i, have, want)
+		}
+	}
+}
+
+func TestNetstatArgs(t *testing.T) {
+	if have, want := netstatArgs(false), []string{"-n", "-W", "-p", "tcp"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %q, want %q", have, want)
+	}
+	if have, want := netstatArgs(true), []string{"-n", "-W", "-p", "tcp", "-a"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+func TestNetstatDarwinAll(t *testing.T) {
+	testString := `Active Internet connections (including servers)
+Proto Recv-Q Send-Q  Local Address          Foreign Address        (state)
+tcp4       0      0  10.0.1.6.58287         1.2.3.4.443            ESTABLISHED
+tcp4       0      0  10.0.1.6.58286         1.2.3.4.443            TIME_WAIT
+tcp4       0      0  *.22                   *.*                    LISTEN
+tcp46      0      0  *.80                   *.*                    LISTEN
+`
+	res := parseDarwinNetstat(testString, true)
+	expected := []struct {
+		state         State
+		local, remote string
+	}{
+		{StateEstablished, "10.0.1.6:58287", "1.2.3.4:443"},
+		{StateTimeWait, "10.0.1.6:58286", "1.2.3.4:443"},
+		{StateListen, "0.0.0.0:22", "0.0.0.0:0"},
+		{StateListen, "[::]:80", "[::]:0"},
+	}
+	if have, want := len(res), len(expected); have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	for i, want := range expected {
+		c := res[i]
+		if c.State != want.state || c.Local().String() != want.local || c.Remote().String() != want.remote {
+			t.Errorf("%d: have %s %s %s, want %+v", i, c.State, c.Local(), c.Remote(), want)
+		}
+	}
+}
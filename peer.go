@@ -0,0 +1,27 @@
+package procspy
+
+import (
+	"net"
+)
+
+// UnixPeer is the process on the other end of a Unix socket.
+type UnixPeer struct {
+	Proc
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+	// Cmdline has the arguments of the process, if we could read them.
+	Cmdline []string `json:"cmdline,omitempty"`
+	// Label is the security label of the peer, if there is a security module
+	// (SELinux, AppArmor, Smack) which sets one.
+	Label string `json:"label,omitempty"`
+}
+
+// UnixPeerProc returns the process which connected to a Unix socket. The
+// PID, UID, and GID are what the kernel recorded on connect(), so there is
+// no /proc walk and it's cheap. Name and Cmdline are read from /proc/<pid>
+// afterwards, so if the PID was reused they're of another process. They're
+// empty if the process is gone or we're not allowed to look at it. Not
+// supported on Darwin.
+func UnixPeerProc(c *net.UnixConn) (UnixPeer, error) {
+	return unixPeer(c)
+}
@@ -0,0 +1,45 @@
+package procspy
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+func unixPeer(c *net.UnixConn) (UnixPeer, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return UnixPeer{}, err
+	}
+	var (
+		cred    *syscall.Ucred
+		credErr error
+		label   string
+	)
+	if err := rc.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+		label = peerSec(int(fd))
+	}); err != nil {
+		return UnixPeer{}, err
+	}
+	if credErr != nil {
+		return UnixPeer{}, os.NewSyscallError("getsockopt", credErr)
+	}
+
+	p := UnixPeer{
+		Proc: Proc{
+			PID: uint(cred.Pid),
+		},
+		UID:   cred.Uid,
+		GID:   cred.Gid,
+		Label: label,
+	}
+	if cred.Pid > 0 {
+		// The PID is 0 if the peer is in a PID namespace we can't see.
+		dirName := strconv.Itoa(int(cred.Pid))
+		p.Name = procName(defaultFiles, dirName)
+		p.Cmdline = procCmdline(defaultFiles, dirName)
+	}
+	return p, nil
+}
@@ -0,0 +1,51 @@
+package procspy
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUnixPeerProc(t *testing.T) {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{
+		Name: filepath.Join(t.TempDir(), "sock"),
+		Net:  "unix",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := l.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	p, err := UnixPeerProc(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := p.PID, uint(os.Getpid()); have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := p.UID, uint32(os.Getuid()); have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := p.GID, uint32(os.Getgid()); have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := p.Name, procName(defaultFiles, "self"); have != want || have == "" {
+		t.Errorf("have %q, want %q", have, want)
+	}
+	if have, want := p.Cmdline, os.Args; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !386
+
+package procspy
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// peerSec reads SO_PEERSEC. It's empty when no security module labels
+// sockets. The syscall package has no getsockopt() for strings.
+func peerSec(fd int) string {
+	buf := make([]byte, 256)
+	for {
+		n := uint32(len(buf))
+		_, _, errno := syscall.Syscall6(
+			syscall.SYS_GETSOCKOPT,
+			uintptr(fd),
+			syscall.SOL_SOCKET,
+			syscall.SO_PEERSEC,
+			uintptr(unsafe.Pointer(&buf[0])),
+			uintptr(unsafe.Pointer(&n)),
+			0,
+		)
+		switch {
+		case errno == 0:
+			return strings.TrimRight(string(buf[:n]), "\x00")
+		case errno == syscall.ERANGE && int(n) > len(buf):
+			// n is the size we need.
+			buf = make([]byte, n)
+		default:
+			return ""
+		}
+	}
+}
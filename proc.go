@@ -3,113 +3,216 @@ package procspy
 // /proc-based implementation.
 
 import (
-	"bytes"
-	"os"
+	"io"
 	"strconv"
-	"syscall"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"fmt"
+	"time"
 )
 
 var (
-	procRoot = "/proc"
+	procRoot    = "/proc"
+	procWorkers = 1
 )
 
 // SetProcRoot sets the location of the proc filesystem.
 func SetProcRoot(root string) {
 	procRoot = root
+	defaultFiles = osFiles(root)
 }
 
-// walkProcPid walks over all numerical (PID) /proc entries, and sees if their
-// ./fd/* files are symlink to sockets. Returns a map from socket ID (inode)
-// to PID. Will return an error if /proc isn't there.
-func walkProcPid(namespaces *map[uint64]struct{}, connBuff *bytes.Buffer) (map[uint64]Proc, error) {
-	fh, err := os.Open(procRoot)
-	if err != nil {
-		return nil, err
+// SetProcWorkers sets how many goroutines walk over /proc in parallel when
+// looking for processes. The default is 1, which walks all processes
+// sequentially. The results are the same for any number of workers.
+func SetProcWorkers(n int) {
+	if n < 1 {
+		n = 1
 	}
+	procWorkers = n
+}
 
-	dirNames, err := fh.Readdirnames(-1)
-	fh.Close()
+// walkProcPid walks over all numerical (PID) /proc entries, and sees if their
+// ./fd/* files are symlink to sockets. Returns a map from socket ID (inode)
+// to PID. Will return an error if /proc isn't there. The tcp tables of every
+// network namespace are added to tables. If cache is not nil processes which
+// didn't change since the previous walk aren't rescanned.
+func walkProcPid(files procFiles, namespaces *map[uint64]struct{}, tables *[]netTable, cache *ProcCache) (map[uint64]Proc, error) {
+	dirNames, err := files.readDirNames(".")
 	if err != nil {
 		return nil, err
 	}
 
 	var (
-		res  = map[uint64]Proc{}
-		stat syscall.Stat_t
+		res     = map[uint64]Proc{}
+		now     time.Time
+		entries = make([]walkEntry, len(dirNames))
 	)
-	for _, dirName := range dirNames {
-		pid, err := strconv.ParseUint(dirName, 10, 0)
-		if err != nil {
-			// Not a number, so not a PID subdir.
-			continue
-		}
+	if cache != nil {
+		cache.begin()
+		defer cache.end()
+		now = time.Now()
+	}
+	parallel(len(dirNames), procWorkers, func(i int) {
+		walkPid(files, dirNames[i], cache, now, &entries[i])
+	})
 
-		fdBase := procRoot + "/" + dirName + "/fd/"
-		dfh, err := os.Open(fdBase)
-		if err != nil {
-			// Process is be gone by now, or we don't have access.
+	// Merge in /proc order, so the result doesn't depend on the workers.
+	for _, e := range entries {
+		if e.p == nil {
 			continue
 		}
-
-		fdNames, err := dfh.Readdirnames(-1)
-		dfh.Close()
-		if err != nil {
-			continue
+		if cache != nil && e.fresh {
+			cache.put(uint(e.pid), e.stamp, now, e.p)
 		}
 
-		// Read network namespace, and if we haven't seen it before,
+		// If we haven't seen the network namespace before we need to
 		// read /proc/<pid>/net/tcp
-		err = syscall.Lstat(fmt.Sprintf("%s/%d/ns/net", procRoot, pid), &stat)
-		if err != nil {
-			continue
+		if _, ok := (*namespaces)[e.p.netns]; !ok {
+			(*namespaces)[e.p.netns] = struct{}{}
+			*tables = append(*tables,
+				netTable{fmt.Sprintf("%d/net/tcp", e.pid), e.p.netns},
+				netTable{fmt.Sprintf("%d/net/tcp6", e.pid), e.p.netns},
+			)
 		}
 
-		if _, ok := (*namespaces)[stat.Ino]; !ok {
-			(*namespaces)[stat.Ino] = struct{}{}
-			readFile(fmt.Sprintf("%s/%d/net/tcp", procRoot, pid), connBuff)
-			readFile(fmt.Sprintf("%s/%d/net/tcp6", procRoot, pid), connBuff)
+		for _, inode := range e.p.inodes {
+			res[inode] = Proc{
+				PID:  uint(e.pid),
+				Name: e.p.name,
+			}
 		}
+	}
 
-		var name string
-		for _, fdName := range fdNames {
-			// Direct use of syscall.Stat() to save garbage.
-			err = syscall.Stat(fdBase+fdName, &stat)
-			if err != nil {
-				continue
-			}
+	return res, nil
+}
 
-			// We want sockets only.
-			if stat.Mode&syscall.S_IFMT != syscall.S_IFSOCK {
-				continue
-			}
+// netTable is a /proc/<pid>/net/tcp{,6} file, and the network namespace it
+// describes.
+type netTable struct {
+	path  string
+	netns uint64
+}
+
+// walkEntry is the result of looking at a single /proc/<pid> directory.
+type walkEntry struct {
+	pid   uint64
+	p     *procInfo // nil if this is not a (readable) process
+	stamp procStamp
+	fresh bool // p is not from the cache
+}
+
+// walkPid looks at a single /proc entry. It's safe to call this concurrently
+// for different entries while the walk holds the cache.
+func walkPid(files procFiles, dirName string, cache *ProcCache, now time.Time, e *walkEntry) {
+	pid, err := strconv.ParseUint(dirName, 10, 0)
+	if err != nil {
+		// Not a number, so not a PID subdir.
+		return
+	}
+	e.pid = pid
 
-			if name == "" {
-				if name = procName(procRoot + "/" + dirName); name == "" {
-					// Process might be gone by now
-					break
+	if cache != nil {
+		if e.stamp, err = readProcStamp(files, dirName); err != nil {
+			// Process is gone by now.
+			return
+		}
+		if e.p = cache.get(uint(pid), e.stamp, now); e.p != nil {
+			return
+		}
+	}
+	e.p = scanProc(files, dirName)
+	e.fresh = true
+}
+
+// parallel calls f(0) up to f(n-1), using at most workers goroutines.
+func parallel(n, workers int, f func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+
+	var (
+		wg   sync.WaitGroup
+		next int64 = -1
+	)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
 				}
+				f(i)
 			}
+		}()
+	}
+	wg.Wait()
+}
+
+// procInfo is what we know about the sockets of a single process.
+type procInfo struct {
+	netns  uint64
+	name   string
+	inodes []uint64
+}
+
+// scanProc looks at the network namespace and the ./fd/* files of a single
+// process. Returns nil if the process is gone, or we don't have access.
+func scanProc(files procFiles, dirName string) *procInfo {
+	fdBase := dirName + "/fd"
+	fdNames, err := files.readDirNames(fdBase)
+	if err != nil {
+		// Process is be gone by now, or we don't have access.
+		return nil
+	}
+
+	// Read network namespace. ns/net is a symlink to the namespace, which
+	// has the inode we want.
+	netns, ok := files.inode(dirName+"/ns/net", false)
+	if !ok {
+		return nil
+	}
+
+	p := &procInfo{
+		netns: netns,
+	}
+	for _, fdName := range fdNames {
+		// We want sockets only.
+		inode, ok := files.inode(fdBase+"/"+fdName, true)
+		if !ok {
+			continue
+		}
 
-			res[stat.Ino] = Proc{
-				PID:  uint(pid),
-				Name: name,
+		if p.name == "" {
+			if p.name = procName(files, dirName); p.name == "" {
+				// Process might be gone by now
+				break
 			}
 		}
-	}
 
-	return res, nil
+		p.inodes = append(p.inodes, inode)
+	}
+	return p
 }
 
-// procName does a pid->name lookup.
-func procName(base string) string {
-	fh, err := os.Open(base + "/comm")
+// procName does a pid->name lookup. dirName is the PID.
+func procName(files procFiles, dirName string) string {
+	fh, err := files.open(dirName + "/comm")
 	if err != nil {
 		return ""
 	}
 
 	name := make([]byte, 64)
-	l, err := fh.Read(name)
+	l, err := readSmall(fh, name)
 	fh.Close()
 	if err != nil {
 		return ""
@@ -123,15 +226,24 @@ func procName(base string) string {
 	return string(name[:l-1])
 }
 
-// readFile reads an arbitrary file into a buffer. It's a variable so it can
-// be overwritten for benchmarks. That's bad practice and we should change it
-// to be a dependency.
-var readFile = func(filename string, buf *bytes.Buffer) error {
-	f, err := os.Open(filename)
+// procCmdline gives the arguments of a process, or nil for kernel threads and
+// processes which are gone.
+func procCmdline(files procFiles, dirName string) []string {
+	fh, err := files.open(dirName + "/cmdline")
 	if err != nil {
-		return err
+		return nil
 	}
-	_, err = buf.ReadFrom(f)
-	f.Close()
-	return err
+	b, err := io.ReadAll(fh)
+	fh.Close()
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\x00"), "\x00")
+}
+
+// openFile opens an arbitrary file. It's a variable so it can be overwritten
+// for benchmarks. That's bad practice and we should change it to be a
+// dependency.
+var openFile = func(files procFiles, filename string) (io.ReadCloser, error) {
+	return files.open(filename)
 }
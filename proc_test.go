@@ -0,0 +1,149 @@
+package procspy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// fakeProc is a process in a synthetic /proc tree.
+type fakeProc struct {
+	pid     int
+	name    string
+	start   uint64
+	netns   string
+	sockets int
+}
+
+// makeProcTree builds a synthetic /proc tree. Every socket is a real socket
+// file, so it has its own inode, and is an established connection in the
+// tcp table of its namespace. Returns the inodes of the sockets per PID.
+func makeProcTree(t testing.TB, root string, procs []fakeProc) map[int][]uint64 {
+	var (
+		inodes = map[int][]uint64{}
+		tables = map[string]*bytes.Buffer{}
+	)
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(os.MkdirAll(root+"/sockets", 0755))
+	must(os.MkdirAll(root+"/netns", 0755))
+	for _, p := range procs {
+		base := fmt.Sprintf("%s/%d", root, p.pid)
+		must(os.MkdirAll(base+"/fd", 0755))
+		must(os.MkdirAll(base+"/ns", 0755))
+		must(os.MkdirAll(base+"/net", 0755))
+		writeProc(t, root, p)
+
+		// Processes in the same namespace share the ns/net inode.
+		ns := root + "/netns/" + p.netns
+		if _, err := os.Stat(ns); err != nil {
+			must(os.WriteFile(ns, nil, 0644))
+		}
+		must(os.Link(ns, base+"/ns/net"))
+		table, ok := tables[p.netns]
+		if !ok {
+			table = bytes.NewBuffer(append([]byte{}, fixture...))
+			tables[p.netns] = table
+		}
+
+		for i := 0; i < p.sockets; i++ {
+			sock := fmt.Sprintf("%s/sockets/%d-%d", root, p.pid, i)
+			must(syscall.Mknod(sock, syscall.S_IFSOCK|0644, 0))
+			must(os.Symlink(sock, fmt.Sprintf("%s/fd/%d", base, i+3)))
+			var stat syscall.Stat_t
+			must(syscall.Stat(sock, &stat))
+			inodes[p.pid] = append(inodes[p.pid], stat.Ino)
+			fmt.Fprintf(table,
+				"  %3d: 0100007F:%04X 0100007F:1F90 01 00000000:00000000 00:00000000 00000000  1000        0 %d 1 0000000000000000 20 4 30 10 -1\n",
+				i, (10000+p.pid*16+i)%65536, stat.Ino,
+			)
+		}
+		// Some non-socket fds.
+		must(os.Symlink("/dev/null", base+"/fd/0"))
+		must(os.Symlink(filepath.Join(base, "comm"), base+"/fd/1"))
+	}
+
+	// /proc/self is the first process, and /proc/net is its net.
+	if len(procs) > 0 {
+		must(os.Symlink(fmt.Sprintf("%d", procs[0].pid), root+"/self"))
+		must(os.Symlink("self/net", root+"/net"))
+	}
+
+	// Every process sees the table of its own namespace.
+	for _, p := range procs {
+		base := fmt.Sprintf("%s/%d", root, p.pid)
+		must(os.WriteFile(base+"/net/tcp", tables[p.netns].Bytes(), 0644))
+		must(os.WriteFile(base+"/net/tcp6", nil, 0644))
+	}
+	return inodes
+}
+
+// writeProc (re)writes the comm and stat files of a process.
+func writeProc(t testing.TB, root string, p fakeProc) {
+	base := fmt.Sprintf("%s/%d", root, p.pid)
+	if err := os.WriteFile(base+"/comm", []byte(p.name+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stat := fmt.Sprintf(
+		"%d (%s) S 1 1 1 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 %d 0 0\n",
+		p.pid, p.name, p.start,
+	)
+	if err := os.WriteFile(base+"/stat", []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkProcPidParallel(t *testing.T) {
+	root := t.TempDir()
+	var procs []fakeProc
+	for i := 0; i < 100; i++ {
+		procs = append(procs, fakeProc{
+			pid:     100 + i,
+			name:    fmt.Sprintf("proc%d", i),
+			start:   uint64(i),
+			netns:   fmt.Sprintf("ns%d", i%7),
+			sockets: i % 3,
+		})
+	}
+	makeProcTree(t, root, procs)
+	defer SetProcRoot(procRoot)
+	SetProcRoot(root)
+	defer SetProcWorkers(procWorkers)
+
+	walk := func(workers int) (map[uint64]Proc, []netTable) {
+		SetProcWorkers(workers)
+		var (
+			netns  = map[uint64]struct{}{}
+			tables []netTable
+		)
+		res, err := walkProcPid(defaultFiles, &netns, &tables, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := len(netns), 7; have != want {
+			t.Errorf("have %d namespaces, want %d", have, want)
+		}
+		return res, tables
+	}
+
+	seqProcs, seqTables := walk(1)
+	if have, want := len(seqProcs), 99; have != want {
+		t.Errorf("have %d sockets, want %d", have, want)
+	}
+	for _, workers := range []int{2, 8, 200} {
+		procs, tables := walk(workers)
+		if !reflect.DeepEqual(procs, seqProcs) {
+			t.Errorf("%d workers: different processes", workers)
+		}
+		if !reflect.DeepEqual(tables, seqTables) {
+			t.Errorf("%d workers: different tables", workers)
+		}
+	}
+}
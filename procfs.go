@@ -0,0 +1,206 @@
+package procspy
+
+// Access to the proc filesystem, either the real one or an fs.FS.
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// procFiles is how we read /proc. Names are relative to the root, such as
+// "42/fd/3", and "." is the root itself.
+type procFiles interface {
+	open(name string) (io.ReadCloser, error)
+	readDirNames(name string) ([]string, error)
+	// inode returns the inode of what an fd/ or ns/ entry links to. If
+	// socket is true it has to be a socket.
+	inode(name string, socket bool) (uint64, bool)
+	stat(name string) (fs.FileInfo, error)
+}
+
+var defaultFiles procFiles = osFiles(procRoot)
+
+// SetProcFS makes the procfs backend read from fsys, instead of from the
+// directory set with SetProcRoot(). Use it for proc trees in tests, or copied
+// from another host. The fd/ and ns/net entries have to be symlinks to
+// "socket:[<inode>]" and "net:[<inode>]", like Linux has them. If fsys can't
+// do symlinks (ReadLink(), see fs.ReadLinkFS) they can be regular files with
+// the link target as content. "self" should point to a process in the
+// namespace you want without processes.
+func SetProcFS(fsys fs.FS) {
+	defaultFiles = fsFiles{fsys}
+}
+
+// filesOrDefault is files, or defaultFiles if that's nil.
+func filesOrDefault(files procFiles) procFiles {
+	if files == nil {
+		return defaultFiles
+	}
+	return files
+}
+
+// procCache remembers the owner and container of every PID, so a scan reads
+// them only once per process. Use a new one for every scan.
+type procCache struct {
+	files      procFiles
+	uids       map[uint]cachedUID
+	containers map[uint]string
+}
+
+type cachedUID struct {
+	uid uint32
+	ok  bool
+}
+
+func newProcCache(files procFiles) *procCache {
+	return &procCache{files: filesOrDefault(files)}
+}
+
+// uid is procUID(), once per PID.
+func (pc *procCache) uid(pid uint) (uint32, bool) {
+	u, ok := pc.uids[pid]
+	if !ok {
+		u.uid, u.ok = procUID(pc.files, pid)
+		if pc.uids == nil {
+			pc.uids = map[uint]cachedUID{}
+		}
+		pc.uids[pid] = u
+	}
+	return u.uid, u.ok
+}
+
+// container is procContainer(), once per PID.
+func (pc *procCache) container(pid uint) string {
+	id, ok := pc.containers[pid]
+	if !ok {
+		id = procContainer(pc.files, pid)
+		if pc.containers == nil {
+			pc.containers = map[uint]string{}
+		}
+		pc.containers[pid] = id
+	}
+	return id
+}
+
+// osFiles is a directory, normally /proc.
+type osFiles string
+
+func (r osFiles) open(name string) (io.ReadCloser, error) {
+	return os.Open(string(r) + "/" + name)
+}
+
+func (r osFiles) readDirNames(name string) ([]string, error) {
+	fh, err := os.Open(string(r) + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return fh.Readdirnames(-1)
+}
+
+func (r osFiles) inode(name string, socket bool) (uint64, bool) {
+	// Direct use of syscall.Stat() to save garbage.
+	var stat syscall.Stat_t
+	if err := syscall.Stat(string(r)+"/"+name, &stat); err != nil {
+		return 0, false
+	}
+	if socket && stat.Mode&syscall.S_IFMT != syscall.S_IFSOCK {
+		return 0, false
+	}
+	return uint64(stat.Ino), true
+}
+
+func (r osFiles) stat(name string) (fs.FileInfo, error) {
+	return os.Stat(string(r) + "/" + name)
+}
+
+// readLinkFS is fs.ReadLinkFS, which is new in Go 1.25.
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// fsFiles is an fs.FS with a proc tree.
+type fsFiles struct {
+	fsys fs.FS
+}
+
+func (f fsFiles) open(name string) (io.ReadCloser, error) {
+	return f.fsys.Open(name)
+}
+
+func (f fsFiles) readDirNames(name string) ([]string, error) {
+	es, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(es))
+	for _, e := range es {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func (f fsFiles) inode(name string, socket bool) (uint64, bool) {
+	var (
+		target string
+		err    = errors.ErrUnsupported
+	)
+	if rl, ok := f.fsys.(readLinkFS); ok {
+		target, err = rl.ReadLink(name)
+	}
+	if err != nil {
+		// Maybe a regular file with the target.
+		fi, err := fs.Stat(f.fsys, name)
+		if err != nil || !fi.Mode().IsRegular() || fi.Size() > 64 {
+			return 0, false
+		}
+		b, err := fs.ReadFile(f.fsys, name)
+		if err != nil {
+			return 0, false
+		}
+		target = string(bytes.TrimSpace(b))
+	}
+	kind, inode, ok := parseLinkTarget(target)
+	if !ok || (socket && kind != "socket") {
+		return 0, false
+	}
+	return inode, true
+}
+
+func (f fsFiles) stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.fsys, name)
+}
+
+// readSmall reads a small file in one go. A single Read() is enough for
+// /proc, but not for every fs.FS.
+func readSmall(r io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return n, err
+}
+
+// parseLinkTarget parses the target of an fd/ or ns/ symlink, such as
+// "socket:[1234]".
+func parseLinkTarget(s string) (string, uint64, bool) {
+	i := len(s) - 1
+	if i < 0 || s[i] != ']' {
+		return "", 0, false
+	}
+	j := strings.IndexByte(s, '[')
+	if j < 2 || s[j-1] != ':' {
+		return "", 0, false
+	}
+	inode, err := strconv.ParseUint(s[j+1:i], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return s[:j-1], inode, true
+}
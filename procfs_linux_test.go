@@ -0,0 +1,207 @@
+package procspy
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProcFS(t *testing.T) {
+	var (
+		link = func(target string) *fstest.MapFile {
+			return &fstest.MapFile{Mode: fs.ModeSymlink, Data: []byte(target)}
+		}
+		file = func(data string) *fstest.MapFile {
+			return &fstest.MapFile{Data: []byte(data)}
+		}
+		tableB = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:1F90 0100007F:D431 01 00000000:00000000 00:00000000 00000000     0        0 777 1 ffff8800a6aaf040 100 0 0 10 0
+`
+	)
+	fsys := fstest.MapFS{
+		"self":          link("42"),
+		"42/comm":       file("nginx\n"),
+		"42/ns/net":     link("net:[4026531840]"),
+		"42/fd/0":       link("/dev/null"),
+		"42/fd/3":       link("socket:[5107]"),
+		"42/fd/4":       link("socket:[10550]"),
+		"42/net/tcp":    &fstest.MapFile{Data: fixture},
+		"42/net/tcp6":   file(""),
+		"net":           link("self/net"),
+		"43/comm":       file("sshd\n"),
+		"43/ns/net":     file("net:[4026531840]"), // no symlinks, like in a zip
+		"43/fd/5":       file("socket:[5084]\n"),
+		"43/fd/6":       file("pipe:[5085]"),
+		"43/net/tcp":    &fstest.MapFile{Data: fixture},
+		"44/comm":       file("envoy\n"),
+		"44/ns/net":     link("net:[4026532000]"),
+		"44/fd/7":       link("socket:[777]"),
+		"44/net/tcp":    file(tableB),
+		"45/comm":       file("gone\n"),
+		"not-a-pid/foo": file(""),
+	}
+	defer SetProcRoot(procRoot)
+	SetProcFS(fsys)
+
+	cs, err := ProcFS{}.Connections(true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := map[uint64]Connection{}
+	for c := cs.Next(); c != nil; c = cs.Next() {
+		have[c.inode] = c.clone()
+	}
+	if err := cs.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for inode, want := range map[uint64]struct {
+		netns uint64
+		proc  Proc
+	}{
+		5107:   {4026531840, Proc{PID: 42, Name: "nginx"}},
+		10550:  {4026531840, Proc{PID: 42, Name: "nginx"}},
+		5084:   {4026531840, Proc{PID: 43, Name: "sshd"}},
+		639474: {4026531840, Proc{}},
+		777:    {4026532000, Proc{PID: 44, Name: "envoy"}},
+	} {
+		c, ok := have[inode]
+		if !ok {
+			t.Errorf("inode %d not found", inode)
+			continue
+		}
+		if c.NetNS != want.netns || c.Proc != want.proc {
+			t.Errorf("inode %d: have %d %v, want %d %v", inode, c.NetNS, c.Proc, want.netns, want.proc)
+		}
+	}
+	if have, want := len(have), 5; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	// Without processes we read the tables of "self".
+	cs, err = ProcFS{}.Connections(false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for c := cs.Next(); c != nil; c = cs.Next() {
+		if have, want := c.NetNS, uint64(4026531840); have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+		n++
+	}
+	if have, want := n, 4; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
+
+func TestProcFSFilter(t *testing.T) {
+	const id = "3f4e1c2b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+	var (
+		link = func(target string) *fstest.MapFile {
+			return &fstest.MapFile{Mode: fs.ModeSymlink, Data: []byte(target)}
+		}
+		file = func(data string) *fstest.MapFile {
+			return &fstest.MapFile{Data: []byte(data)}
+		}
+	)
+	fsys := fstest.MapFS{
+		"self":        link("42"),
+		"42/comm":     file("nginx\n"),
+		"42/status":   file("Name:\tnginx\nUid:\t0\t33\t33\t33\nGid:\t0\t33\t33\t33\n"),
+		"42/cgroup":   file("0::/system.slice/docker-" + id + ".scope\n"),
+		"42/ns/net":   link("net:[4026531840]"),
+		"42/fd/3":     link("socket:[5107]"),
+		"42/net/tcp":  &fstest.MapFile{Data: fixture},
+		"43/comm":     file("sshd\n"),
+		"43/status":   file("Name:\tsshd\nUid:\t0\t0\t0\t0\n"),
+		"43/cgroup":   file("0::/system.slice/sshd.service\n"),
+		"43/ns/net":   link("net:[4026531840]"),
+		"43/fd/3":     link("socket:[5084]"),
+		"43/net/tcp6": file(""),
+	}
+	// Nothing of this host is used.
+	defer SetProcRoot(procRoot)
+	SetProcRoot(t.TempDir())
+
+	s := NewScanner(ProcFS{FS: fsys})
+	for expr, want := range map[string][]Proc{
+		"uid 33":                        {{PID: 42, Name: "nginx"}},
+		"uid 0":                         {{PID: 43, Name: "sshd"}},
+		"container " + id[:12]:          {{PID: 42, Name: "nginx"}},
+		"uid 0 and not container " + id: {{PID: 43, Name: "sshd"}},
+	} {
+		f, err := ParseFilter(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs, err := s.Select(f, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var have []Proc
+		for c := cs.Next(); c != nil; c = cs.Next() {
+			have = append(have, c.Proc)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%q: have %v, want %v", expr, have, want)
+		}
+	}
+}
+
+func TestFilterProcCache(t *testing.T) {
+	const id = "3f4e1c2b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+	var (
+		nginx = Proc{PID: 42, Name: "nginx"}
+		fsys  = &countedFS{MapFS: fstest.MapFS{
+			"42/status": &fstest.MapFile{Data: []byte("Name:\tnginx\nUid:\t0\t33\t33\t33\n")},
+			"42/cgroup": &fstest.MapFile{Data: []byte("0::/system.slice/docker-" + id + ".scope\n")},
+		}}
+	)
+	defer SetProcRoot(procRoot)
+	SetProcFS(fsys)
+	f, err := ParseFilter("uid 33 and container " + id[:12])
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := fixedConnIter{
+		testConnection(StateListen, "0.0.0.0:443", "0.0.0.0:0", 1, nginx),
+		testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.5:50123", 1, nginx),
+		testConnection(StateEstablished, "10.0.0.1:443", "192.168.1.6:50124", 1, nginx),
+	}
+	it := f.Iter(&cs)
+	n := 0
+	for c := it.Next(); c != nil; c = it.Next() {
+		n++
+	}
+	if have, want := n, 3; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	for _, name := range []string{"status", "cgroup"} {
+		if have, want := fsys.opens[name], 1; have != want {
+			t.Errorf("%s: have %d reads, want %d", name, have, want)
+		}
+	}
+}
+
+func TestParseLinkTarget(t *testing.T) {
+	for target, want := range map[string]struct {
+		kind  string
+		inode uint64
+		ok    bool
+	}{
+		"socket:[1234]":        {"socket", 1234, true},
+		"net:[4026531840]":     {"net", 4026531840, true},
+		"anon_inode:[eventfd]": {},
+		"/dev/null":            {},
+		"socket:[]":            {},
+		":[12]":                {},
+		"]":                    {},
+		"":                     {},
+	} {
+		kind, inode, ok := parseLinkTarget(target)
+		if kind != want.kind || inode != want.inode || ok != want.ok {
+			t.Errorf("%q: have %q %d %v, want %+v", target, kind, inode, ok, want)
+		}
+	}
+}
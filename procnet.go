@@ -2,118 +2,335 @@ package procspy
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"net"
 )
 
 // ProcNet is an iterator to parse /proc/net/tcp{,6} files.
 type ProcNet struct {
-	b                       []byte
-	c                       Connection
-	wantedState             uint
-	bytesLocal, bytesRemote [16]byte
+	b []byte
+	lineParser
 }
 
-// NewProcNet gives a new ProcNet parser.
+// NewProcNet gives a new ProcNet parser. It only gives connections in
+// wantedState, or in any state if wantedState is 0.
 func NewProcNet(b []byte, wantedState uint) *ProcNet {
-	return &ProcNet{
-		b:           b,
-		c:           Connection{},
-		wantedState: wantedState,
+	p := &ProcNet{
+		b: b,
+		lineParser: lineParser{
+			wantedState: wantedState,
+			order:       nativeEndian,
+		},
 	}
+	p.reset()
+	return p
+}
+
+// Err returns the first error, if any. Next() will return nil after an error.
+func (p *ProcNet) Err() error {
+	return p.err
 }
 
 // Next returns the next connection. All buffers are re-used, so if you want
 // to keep the IPs you have to copy them.
 func (p *ProcNet) Next() *Connection {
-again:
-	if len(p.b) == 0 {
+	for len(p.b) > 0 && p.err == nil {
+		line := p.b
+		if i := bytes.IndexByte(line, '\n'); i != -1 {
+			line, p.b = line[:i], line[i+1:]
+		} else {
+			p.b = nil
+		}
+		if c := p.parse(line); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// ParseError is a malformed line in a /proc/net/tcp{,6} file.
+type ParseError struct {
+	Line int // 1-based
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// procNetColumns are the positions of the columns we use.
+type procNetColumns struct {
+	local, remote, state, inode int
+	queue                       int // "tx_queue:rx_queue", -1 if there is none
+}
+
+// defaultColumns is the layout without a header, which is the layout every
+// kernel since 2.6 uses.
+var defaultColumns = procNetColumns{local: 1, remote: 2, state: 3, inode: 9, queue: 4}
+
+// maxProcNetFields is the number of fields of a line we look at.
+const maxProcNetFields = 20
+
+// lineParser parses single lines of /proc/net/tcp{,6} files.
+type lineParser struct {
+	c                       Connection
+	wantedState             uint
+	bytesLocal, bytesRemote [16]byte
+	order                   binary.ByteOrder
+	cols                    procNetColumns
+	line                    int
+	err                     error
+}
+
+// SetByteOrder sets the byte order of the machine which wrote the table. The
+// default is the byte order of this machine, which is what you want unless
+// you parse a table captured on another machine.
+func (p *lineParser) SetByteOrder(order binary.ByteOrder) {
+	p.order = order
+}
+
+func (p *lineParser) reset() {
+	p.cols = defaultColumns
+	p.line = 0
+	p.err = nil
+}
+
+func (p *lineParser) fail(format string, args ...interface{}) {
+	p.err = &ParseError{
+		Line: p.line,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+// parse parses a single line, without the newline. Returns nil for the header,
+// for empty lines, for connections not in the wanted state, and after an
+// error.
+func (p *lineParser) parse(b []byte) *Connection {
+	if p.err != nil {
 		return nil
 	}
-	b := p.b
+	p.line++
 
-	if p.b[2] == 's' {
-		// Skip header
-		p.b = nextLine(b)
-		goto again
+	if cols, ok, err := parseHeader(b); ok {
+		if err != "" {
+			p.fail("invalid header: %s", err)
+			return nil
+		}
+		p.cols = cols
+		return nil
 	}
 
 	var (
-		local, remote, state, inode []byte
+		fields [maxProcNetFields][]byte
+		n      int
 	)
-	_, b = nextField(b) // 'sl' column
-	local, b = nextField(b)
-	remote, b = nextField(b)
-	state, b = nextField(b)
-	if parseHex(state) != p.wantedState {
-		p.b = nextLine(b)
-		goto again
-	}
-	_, b = nextField(b) // 'tx_queue' column
-	_, b = nextField(b) // 'rx_queue' column
-	_, b = nextField(b) // 'tr' column
-	_, b = nextField(b) // 'uid' column
-	_, b = nextField(b) // 'timeout' column
-	inode, b = nextField(b)
-
-	p.c.LocalAddress, p.c.LocalPort = scanAddressNA(local, &p.bytesLocal)
-	p.c.RemoteAddress, p.c.RemotePort = scanAddressNA(remote, &p.bytesRemote)
+	for n < len(fields) {
+		if fields[n], b = nextField(b); fields[n] == nil {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	if n <= p.cols.max() {
+		p.fail("want at least %d fields, have %d", p.cols.max()+1, n)
+		return nil
+	}
+
+	state := fields[p.cols.state]
+	if len(state) != 2 || !isHex(state) {
+		p.fail("invalid state %q", state)
+		return nil
+	}
+	st := parseHex(state)
+	if p.wantedState != 0 && st != p.wantedState {
+		return nil
+	}
+	p.c.State = State(st)
+
+	var ok bool
+	local, remote, inode := fields[p.cols.local], fields[p.cols.remote], fields[p.cols.inode]
+	if p.c.LocalAddress, p.c.LocalPort, ok = scanAddressNA(local, &p.bytesLocal, p.order); !ok {
+		p.fail("invalid local address %q", local)
+		return nil
+	}
+	if p.c.RemoteAddress, p.c.RemotePort, ok = scanAddressNA(remote, &p.bytesRemote, p.order); !ok {
+		p.fail("invalid remote address %q", remote)
+		return nil
+	}
+	if len(inode) == 0 || len(inode) > 20 || !isDec(inode) {
+		p.fail("invalid inode %q", inode)
+		return nil
+	}
 	p.c.inode = parseDec(inode)
-	p.b = nextLine(b)
+	// The queues are informational, they're 0 when we can't parse them.
+	p.c.SendQ, p.c.RecvQ = 0, 0
+	if p.cols.queue >= 0 && p.cols.queue < n {
+		p.c.SendQ, p.c.RecvQ = parseQueues(fields[p.cols.queue])
+	}
 	return &p.c
 }
 
+// parseQueues parses "tx:rx", two hex numbers.
+func parseQueues(b []byte) (uint32, uint32) {
+	i := bytes.IndexByte(b, ':')
+	if i < 1 || i > 8 || len(b)-i-1 < 1 || len(b)-i-1 > 8 || !isHex(b[:i]) || !isHex(b[i+1:]) {
+		return 0, 0
+	}
+	return uint32(parseHex(b[:i])), uint32(parseHex(b[i+1:]))
+}
+
+// max is the highest column we need.
+func (c procNetColumns) max() int {
+	m := c.local
+	if c.remote > m {
+		m = c.remote
+	}
+	if c.state > m {
+		m = c.state
+	}
+	if c.inode > m {
+		m = c.inode
+	}
+	return m
+}
+
+// parseHeader finds the columns we need by name. ok is false if this is not a
+// header line.
+func parseHeader(b []byte) (cols procNetColumns, ok bool, err string) {
+	f, b := nextField(b)
+	if string(f) != "sl" {
+		return cols, false, ""
+	}
+
+	cols = procNetColumns{-1, -1, -1, -1, -1}
+	for col := 1; ; {
+		if f, b = nextField(b); f == nil {
+			break
+		}
+		switch {
+		case fieldIs(f, "rx_queue"), fieldIs(f, "tm->when"):
+			// Same column as 'tx_queue' and 'tr' in the data.
+			continue
+		case fieldIs(f, "local_address"):
+			cols.local = col
+		case fieldIs(f, "rem_address"), fieldIs(f, "remote_address"):
+			cols.remote = col
+		case fieldIs(f, "st"):
+			cols.state = col
+		case fieldIs(f, "inode"):
+			cols.inode = col
+		case fieldIs(f, "tx_queue"):
+			cols.queue = col
+		}
+		col++
+	}
+
+	switch {
+	case cols.local == -1:
+		return cols, true, "no local_address column"
+	case cols.remote == -1:
+		return cols, true, "no rem_address column"
+	case cols.state == -1:
+		return cols, true, "no st column"
+	case cols.inode == -1:
+		return cols, true, "no inode column"
+	case cols.max() >= maxProcNetFields:
+		return cols, true, "too many columns"
+	}
+	return cols, true, ""
+}
+
+// fieldIs compares a field to a lowercase name, ignoring case.
+func fieldIs(f []byte, name string) bool {
+	if len(f) != len(name) {
+		return false
+	}
+	for i, c := range f {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != name[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // scanAddressNA parses 'A12CF62E:00AA' to the address/port. Handles IPv4 and
-// IPv6 addresses. The address is a big endian 32 bit ints, hex encoded. We
-// just decode the hex and flip the bytes in every group of 4.
-func scanAddressNA(in []byte, buf *[16]byte) (net.IP, uint16) {
+// IPv6 addresses. The address is written as 32 bit ints in the byte order of
+// the machine, hex encoded. The port is always written big endian.
+func scanAddressNA(in []byte, buf *[16]byte, order binary.ByteOrder) (net.IP, uint16, bool) {
 	col := bytes.IndexByte(in, ':')
 	if col == -1 {
-		return nil, 0
+		return nil, 0, false
+	}
+	addr, port := in[:col], in[col+1:]
+	if (len(addr) != 8 && len(addr) != 32) || !isHex(addr) ||
+		len(port) != 4 || !isHex(port) {
+		return nil, 0, false
 	}
 
-	// Network address is big endian. Can be either ipv4 or ipv6.
-	address := hexDecode32bigNA(in[:col], buf)
-	return net.IP(address), uint16(parseHex(in[col+1:]))
+	// Can be either ipv4 or ipv6.
+	address := hexDecode32bigNA(addr, buf, order)
+	return net.IP(address), uint16(parseHex(port)), true
 }
 
-// hexDecode32big decodes sequences of 32bit big endian bytes.
-func hexDecode32bigNA(src []byte, buf *[16]byte) []byte {
+// hexDecode32bigNA decodes sequences of 32 bit ints in the given byte order
+// to the bytes they were in memory. Those are the bytes of the network
+// address.
+func hexDecode32bigNA(src []byte, buf *[16]byte, order binary.ByteOrder) []byte {
 	blocks := len(src) / 8
 	for block := 0; block < blocks; block++ {
-		for i := 0; i < 4; i++ {
-			a := fromHexChar(src[block*8+i*2])
-			b := fromHexChar(src[block*8+i*2+1])
-			buf[block*4+3-i] = (a << 4) | b
-		}
+		order.PutUint32(buf[block*4:], uint32(parseHex(src[block*8:block*8+8])))
 	}
 	return buf[:blocks*4]
 }
 
+// nextField returns the next whitespace separated field, and the rest. The
+// field is nil if there are no more fields.
 func nextField(s []byte) ([]byte, []byte) {
 	// Skip whitespace.
-	for i, b := range s {
-		if b != ' ' {
-			s = s[i:]
-			break
-		}
+	i := 0
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	s = s[i:]
+	if len(s) == 0 {
+		return nil, nil
 	}
 
 	// Up until the next whitespace field.
 	for i, b := range s {
-		if b == ' ' {
+		if isSpace(b) {
 			return s[:i], s[i:]
 		}
 	}
+	return s, nil
+}
 
-	return nil, nil
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
 }
 
-func nextLine(s []byte) []byte {
-	i := bytes.IndexByte(s, '\n')
-	if i == -1 {
-		return nil
+func isHex(s []byte) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func isDec(s []byte) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
-	return s[i+1:]
+	return true
 }
 
 // Simplified copy of strconv.ParseUint(16).
@@ -136,20 +353,6 @@ func parseDec(s []byte) uint64 {
 	return n
 }
 
-// hexDecode32big decodes sequences of 32bit big endian bytes.
-func hexDecode32big(src []byte) []byte {
-	dst := make([]byte, len(src)/2)
-	blocks := len(src) / 8
-	for block := 0; block < blocks; block++ {
-		for i := 0; i < 4; i++ {
-			a := fromHexChar(src[block*8+i*2])
-			b := fromHexChar(src[block*8+i*2+1])
-			dst[block*4+3-i] = (a << 4) | b
-		}
-	}
-	return dst
-}
-
 // fromHexChar converts a hex character into its value.
 func fromHexChar(c byte) uint8 {
 	switch {
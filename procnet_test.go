@@ -1,8 +1,10 @@
 package procspy
 
 import (
+	"encoding/binary"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -11,15 +13,17 @@ func TestProcNet(t *testing.T) {
    0: 00000000:A6C0 00000000:0000 01 00000000:00000000 00:00000000 00000000   105        0 5107 1 ffff8800a6aaf040 100 0 0 10 0                      
    1: 00000000:006F 00000000:0000 01 00000000:00000000 00:00000000 00000000     0        0 5084 1 ffff8800a6aaf740 100 0 0 10 0                      
    2: 0100007F:0019 00000000:0000 01 00000000:00000000 00:00000000 00000000     0        0 10550 1 ffff8800a729b780 100 0 0 10 0                     
-   3: A12CF62E:E4D7 57FC1EC0:01BB 01 00000000:00000000 02:000006FA 00000000  1000        0 639474 2 ffff88007e75a740 48 4 26 10 -1                   
+   3: A12CF62E:E4D7 57FC1EC0:01BB 01 000005A8:00000010 02:000006FA 00000000  1000        0 639474 2 ffff88007e75a740 48 4 26 10 -1                   
 `
 	p := NewProcNet([]byte(testString), tcpEstablished)
+	p.SetByteOrder(binary.LittleEndian)
 	expected := []Connection{
 		{
 			LocalAddress:  net.IP([]byte{0, 0, 0, 0}),
 			LocalPort:     0xa6c0,
 			RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
 			RemotePort:    0x0,
+			State:         StateEstablished,
 			inode:         5107,
 		},
 		{
@@ -27,6 +31,7 @@ func TestProcNet(t *testing.T) {
 			LocalPort:     0x006f,
 			RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
 			RemotePort:    0x0,
+			State:         StateEstablished,
 			inode:         5084,
 		},
 		{
@@ -34,6 +39,7 @@ func TestProcNet(t *testing.T) {
 			LocalPort:     0x0019,
 			RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
 			RemotePort:    0x0,
+			State:         StateEstablished,
 			inode:         10550,
 		},
 		{
@@ -41,6 +47,9 @@ func TestProcNet(t *testing.T) {
 			LocalPort:     0xe4d7,
 			RemoteAddress: net.IP([]byte{0xc0, 0x1e, 0xfc, 0x57}),
 			RemotePort:    0x01bb,
+			State:         StateEstablished,
+			SendQ:         1448,
+			RecvQ:         16,
 			inode:         639474,
 		},
 	}
@@ -65,6 +74,7 @@ func TestTransport6(t *testing.T) {
 `
 
 	p := NewProcNet([]byte(testString), tcpEstablished)
+	p.SetByteOrder(binary.LittleEndian)
 	expected := []Connection{
 		{
 			// state:         10,
@@ -72,6 +82,7 @@ func TestTransport6(t *testing.T) {
 			LocalPort:     0x19c8,
 			RemoteAddress: net.IP(make([]byte, 16)),
 			RemotePort:    0x0,
+			State:         StateEstablished,
 			// uid:           0,
 			inode: 23661201,
 		},
@@ -91,6 +102,7 @@ func TestTransport6(t *testing.T) {
 				0, 0, 0x10, 0x15,
 			}),
 			RemotePort: 0x01bb,
+			State:      StateEstablished,
 			// uid:        1000,
 			inode: 36856710,
 		},
@@ -115,24 +127,114 @@ func TestTransportNonsense(t *testing.T) {
 broken line
 `
 	p := NewProcNet([]byte(testString), tcpEstablished)
-	expected := []Connection{
-		{
-			LocalAddress:  net.IP([]byte{0, 0, 0, 0}),
-			LocalPort:     0xa6c0,
-			RemoteAddress: net.IP([]byte{0, 0, 0, 0}),
-			RemotePort:    0x0,
-		},
+	if got := p.Next(); got != nil {
+		t.Errorf("p.Next() wasn't empty")
 	}
+	err, ok := p.Err().(*ParseError)
+	if !ok {
+		t.Fatalf("have %v, want a ParseError", p.Err())
+	}
+	if have, want := err.Line, 2; have != want {
+		t.Errorf("have line %d, want %d", have, want)
+	}
+}
 
-	for i := 0; i < 1; i++ {
-		have := p.Next()
-		want := expected[i]
-		if !reflect.DeepEqual(*have, want) {
-			t.Errorf("Got\n%+v\nExpected\n%+v\n", *have, want)
+func TestProcNetErrors(t *testing.T) {
+	header := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	line := "   0: 0100007F:0019 0100007F:A6C0 01 00000000:00000000 00:00000000 00000000     0        0 10550 1 ffff8800a729b780 100 0 0 10 0\n"
+	for input, wantLine := range map[string]int{
+		line + "   1: 0100007F:0019\n":                                                                          2,
+		header + line + strings.Replace(line, "0100007F:0019", "0100007G:0019", 1):                              3,
+		header + strings.Replace(line, "0100007F:0019", "0100007F0019", 1):                                      2,
+		header + strings.Replace(line, "0100007F:0019", "100007F:0019", 1):                                      2,
+		header + strings.Replace(line, "0100007F:A6C0", "0100007F:A6C", 1):                                      2,
+		header + strings.Replace(line, " 01 ", " 0x ", 1):                                                       2,
+		header + strings.Replace(line, " 10550 ", " 10a50 ", 1):                                                 2,
+		"  sl  local_address st inode\n" + line:                                                                 1,
+		strings.Repeat("\n", 4) + "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when inode\nX": 6,
+	} {
+		p := NewProcNet([]byte(input), tcpEstablished)
+		for c := p.Next(); c != nil; c = p.Next() {
+		}
+		err, ok := p.Err().(*ParseError)
+		if !ok {
+			t.Errorf("%q: have %v, want a ParseError", input, p.Err())
+			continue
+		}
+		if have, want := err.Line, wantLine; have != want {
+			t.Errorf("%q: have line %d, want %d (%s)", input, have, want, err)
 		}
 	}
-	if got := p.Next(); got != nil {
-		t.Errorf("p.Next() wasn't empty")
+}
+
+func TestProcNetLayout(t *testing.T) {
+	// CRLF, tabs, and the inode before the uid.
+	testString := "sl\tlocal_address rem_address st tx_queue rx_queue tr tm->when retrnsmt inode\tuid\r\n" +
+		"0:\t0100007F:0019 0100007F:A6C0\t01 00000000:00000000 00:00000000 00000000 10550\t1000\r\n" +
+		"\r\n" +
+		"1: 0100007F:0019 0100007F:A6C1 01 00000000:00000000 00:00000000 00000000 10551 1000"
+	p := NewProcNet([]byte(testString), tcpEstablished)
+	var inodes []uint64
+	for c := p.Next(); c != nil; c = p.Next() {
+		inodes = append(inodes, c.inode)
+	}
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := inodes, []uint64{10550, 10551}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+func TestByteOrder(t *testing.T) {
+	// The same connections, as written by a little and a big endian machine:
+	// 10.1.2.3 -> 192.0.2.1, 2001:db8::1 -> fe80::1:2, and ::ffff:10.0.0.1 ->
+	// ::ffff:192.0.2.1.
+	header := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	rest := " 01 00000000:00000000 00:00000000 00000000  1000        0 1234 1 0000000000000000 20 4 30 10 -1\n"
+	tables := map[binary.ByteOrder]string{
+		binary.LittleEndian: header +
+			"   0: 0302010A:1F90 010200C0:01BB" + rest +
+			"   1: B80D0120000000000000000001000000:1F90 000080FE000000000000000002000100:01BB" + rest +
+			"   2: 0000000000000000FFFF00000100000A:1F90 0000000000000000FFFF0000010200C0:01BB" + rest,
+		binary.BigEndian: header +
+			"   0: 0A010203:1F90 C0000201:01BB" + rest +
+			"   1: 20010DB8000000000000000000000001:1F90 FE800000000000000000000000010002:01BB" + rest +
+			"   2: 00000000000000000000FFFF0A000001:1F90 00000000000000000000FFFFC0000201:01BB" + rest,
+	}
+	expected := [][2]string{
+		{"10.1.2.3", "192.0.2.1"},
+		{"2001:db8::1", "fe80::1:2"},
+		{"10.0.0.1", "192.0.2.1"},
+	}
+	for order, table := range tables {
+		p := NewProcNet([]byte(table), tcpEstablished)
+		p.SetByteOrder(order)
+		for i, want := range expected {
+			c := p.Next()
+			if c == nil {
+				t.Fatalf("%s: %d: no connection: %v", order, i, p.Err())
+			}
+			if have := c.LocalAddress.String(); have != want[0] {
+				t.Errorf("%s: %d: have %s, want %s", order, i, have, want[0])
+			}
+			if have := c.RemoteAddress.String(); have != want[1] {
+				t.Errorf("%s: %d: have %s, want %s", order, i, have, want[1])
+			}
+			if have, want := c.LocalPort, uint16(8080); have != want {
+				t.Errorf("%s: %d: have %d, want %d", order, i, have, want)
+			}
+		}
+		if c := p.Next(); c != nil {
+			t.Errorf("%s: p.Next() wasn't empty", order)
+		}
 	}
 
+	var buf [16]byte
+	if have, want := hexDecode32bigNA([]byte("0302010A0A010203"), &buf, binary.BigEndian), []byte{3, 2, 1, 10, 10, 1, 2, 3}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := hexDecode32bigNA([]byte("0302010A0A010203"), &buf, binary.LittleEndian), []byte{10, 1, 2, 3, 3, 2, 1, 10}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
 }
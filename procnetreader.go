@@ -0,0 +1,112 @@
+package procspy
+
+import (
+	"bytes"
+	"io"
+)
+
+const procNetReaderSize = 4096
+
+// ProcNetReader is like ProcNet, but it reads /proc/net/tcp{,6} files from an
+// io.Reader in fixed size chunks, so it never needs the whole table in
+// memory. Lines which don't fit in a chunk are an error.
+type ProcNetReader struct {
+	r          io.Reader
+	buf        []byte
+	start, end int // unparsed bytes in buf
+	readErr    error
+	lineParser
+}
+
+// NewProcNetReader gives a new ProcNetReader parser. It only gives
+// connections in wantedState, or in any state if wantedState is 0.
+func NewProcNetReader(r io.Reader, wantedState uint) *ProcNetReader {
+	return newProcNetReaderSize(r, wantedState, procNetReaderSize)
+}
+
+func newProcNetReaderSize(r io.Reader, wantedState uint, size int) *ProcNetReader {
+	p := &ProcNetReader{
+		r:   r,
+		buf: make([]byte, size),
+		lineParser: lineParser{
+			wantedState: wantedState,
+			order:       nativeEndian,
+		},
+	}
+	p.reset()
+	return p
+}
+
+// Reset makes the parser read from r, keeping its buffers. Use this to parse
+// many files without allocating a new parser every time.
+func (p *ProcNetReader) Reset(r io.Reader) {
+	p.r = r
+	p.start, p.end = 0, 0
+	p.readErr = nil
+	p.reset()
+}
+
+// Err returns the first parse error, or the first read error other than
+// io.EOF. Next() will return nil after an error.
+func (p *ProcNetReader) Err() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.readErr == io.EOF {
+		return nil
+	}
+	return p.readErr
+}
+
+// Next returns the next connection. All buffers are re-used, so if you want
+// to keep the IPs you have to copy them.
+func (p *ProcNetReader) Next() *Connection {
+	for p.err == nil {
+		line, ok := p.nextLine()
+		if !ok {
+			return nil
+		}
+		if c := p.parse(line); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// nextLine returns the next complete line, without the newline. The last line
+// doesn't need a newline.
+func (p *ProcNetReader) nextLine() ([]byte, bool) {
+	for {
+		if i := bytes.IndexByte(p.buf[p.start:p.end], '\n'); i != -1 {
+			line := p.buf[p.start : p.start+i]
+			p.start += i + 1
+			return line, true
+		}
+
+		if p.readErr != nil {
+			if p.start == p.end {
+				return nil, false
+			}
+			line := p.buf[p.start:p.end]
+			p.start = p.end
+			return line, true
+		}
+
+		// Move the partial line to the front and read more.
+		if p.start > 0 {
+			p.end = copy(p.buf, p.buf[p.start:p.end])
+			p.start = 0
+		}
+		if p.end == len(p.buf) {
+			// No newline in a full buffer.
+			p.line++
+			p.fail("line too long")
+			return nil, false
+		}
+		n, err := p.r.Read(p.buf[p.end:])
+		p.end += n
+		if err != nil {
+			p.readErr = err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package procspy
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestProcNetReader(t *testing.T) {
+	table := string(fixture) + string(fixture) + string(fixture[:len(fixture)-20])
+
+	// What ProcNet makes of it.
+	var expected []Connection
+	p := NewProcNet([]byte(table), tcpEstablished)
+	for c := p.Next(); c != nil; c = p.Next() {
+		expected = append(expected, c.clone())
+	}
+
+	for _, size := range []int{160, 200, 1000, procNetReaderSize} {
+		for name, r := range map[string]func() *ProcNetReader{
+			"plain": func() *ProcNetReader {
+				return newProcNetReaderSize(strings.NewReader(table), tcpEstablished, size)
+			},
+			"one byte": func() *ProcNetReader {
+				return newProcNetReaderSize(iotest.OneByteReader(strings.NewReader(table)), tcpEstablished, size)
+			},
+			"data err": func() *ProcNetReader {
+				return newProcNetReaderSize(iotest.DataErrReader(strings.NewReader(table)), tcpEstablished, size)
+			},
+		} {
+			pr := r()
+			var have []Connection
+			for c := pr.Next(); c != nil; c = pr.Next() {
+				have = append(have, c.clone())
+			}
+			if have, want := pr.Err(), p.Err(); !reflect.DeepEqual(have, want) {
+				t.Errorf("size %d, %s: have %v, want %v", size, name, have, want)
+			}
+			if !reflect.DeepEqual(have, expected) {
+				t.Errorf("size %d, %s: have\n%+v\nwant\n%+v", size, name, have, expected)
+			}
+		}
+	}
+}
+
+func TestProcNetReaderLongLines(t *testing.T) {
+	line := "   3: A12CF62E:E4D7 57FC1EC0:01BB 01 00000000:00000000 02:000006FA 00000000  1000        0 639474 2 ffff88007e75a740 48 4 26 10 -1\n"
+	table := line + line + strings.Repeat("x", 300) + "\n" + line
+	p := newProcNetReaderSize(strings.NewReader(table), tcpEstablished, 200)
+	n := 0
+	for c := p.Next(); c != nil; c = p.Next() {
+		if have, want := c.inode, uint64(639474); have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+		n++
+	}
+	if have, want := n, 2; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	err, ok := p.Err().(*ParseError)
+	if !ok {
+		t.Fatalf("have %v, want a ParseError", p.Err())
+	}
+	if have, want := err.Line, 3; have != want {
+		t.Errorf("have line %d, want %d", have, want)
+	}
+
+	p.Reset(iotest.ErrReader(iotest.ErrTimeout))
+	if c := p.Next(); c != nil {
+		t.Errorf("have %v, want nil", c)
+	}
+	if have, want := p.Err(), iotest.ErrTimeout; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+func TestProcNetReaderAllocs(t *testing.T) {
+	var (
+		r = bytes.NewReader(fixture)
+		p = NewProcNetReader(r, tcpEstablished)
+	)
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(fixture)
+		p.Reset(r)
+		for c := p.Next(); c != nil; c = p.Next() {
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("have %f allocs, want 0", allocs)
+	}
+}
@@ -0,0 +1,121 @@
+// Package procspytest has a programmable procspy backend, for testing code
+// which uses procspy.
+//
+//	fake := procspytest.New(
+//		procspytest.Step{Connections: before},
+//		procspytest.Step{Connections: after},
+//	)
+//	s := fake.Scanner()
+//	// s.Connections() gives before, then after, then after again.
+package procspytest
+
+import (
+	"sync"
+
+	"github.com/alicebob/procspy"
+)
+
+// Step is the result of a single call to Connections().
+type Step struct {
+	// Connections are all sockets, in any state. They are filtered on the
+	// arguments of the call: the Proc fields are cleared if processes is
+	// false, and only established connections are returned if all is false.
+	Connections []procspy.Connection
+	// Err is returned by Connections(), which doesn't return connections
+	// then.
+	Err error
+	// IterErr is returned by Err() of the iterator, after all connections.
+	// Use it for partial results.
+	IterErr error
+	// Hidden are the PIDs of processes we're not allowed to look at, as when
+	// we're not root. Their connections are returned without a Proc.
+	Hidden []uint
+}
+
+// Fake is a procspy.Backend which returns its steps one by one. The last step
+// is repeated forever. No steps is the same as a single empty step. A Fake is
+// safe to use from multiple goroutines.
+type Fake struct {
+	mu    sync.Mutex
+	steps []Step
+	calls int
+}
+
+// New makes a Fake with steps.
+func New(steps ...Step) *Fake {
+	return &Fake{steps: steps}
+}
+
+// Push adds a step.
+func (f *Fake) Push(s Step) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.steps = append(f.steps, s)
+}
+
+// Calls returns how often Connections() was called.
+func (f *Fake) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// Scanner gives a procspy.Scanner which uses this Fake.
+func (f *Fake) Scanner() *procspy.Scanner {
+	return procspy.NewScanner(f)
+}
+
+// Connections implements procspy.Backend.
+func (f *Fake) Connections(processes, all bool) (procspy.ConnIter, error) {
+	f.mu.Lock()
+	var s Step
+	if len(f.steps) > 0 {
+		i := f.calls
+		if i >= len(f.steps) {
+			i = len(f.steps) - 1
+		}
+		s = f.steps[i]
+	}
+	f.calls++
+	f.mu.Unlock()
+
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	hidden := map[uint]bool{}
+	for _, pid := range s.Hidden {
+		hidden[pid] = true
+	}
+	it := &iter{err: s.IterErr}
+	for _, c := range s.Connections {
+		if !all && c.State != procspy.StateEstablished {
+			continue
+		}
+		if !processes || hidden[c.PID] {
+			c.Proc = procspy.Proc{}
+		}
+		it.cs = append(it.cs, c)
+	}
+	return it, nil
+}
+
+type iter struct {
+	cs  []procspy.Connection
+	err error
+}
+
+func (it *iter) Next() *procspy.Connection {
+	if len(it.cs) == 0 {
+		return nil
+	}
+	c := it.cs[0]
+	it.cs = it.cs[1:]
+	return &c
+}
+
+func (it *iter) Err() error {
+	if len(it.cs) > 0 {
+		return nil
+	}
+	return it.err
+}
@@ -0,0 +1,126 @@
+package procspytest
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/alicebob/procspy"
+)
+
+func conn(state procspy.State, localPort uint16, pid uint, name string) procspy.Connection {
+	return procspy.Connection{
+		Transport:     "tcp",
+		LocalAddress:  net.ParseIP("10.0.0.1"),
+		LocalPort:     localPort,
+		RemoteAddress: net.ParseIP("10.0.0.2"),
+		RemotePort:    4000,
+		State:         state,
+		Proc: procspy.Proc{
+			PID:  pid,
+			Name: name,
+		},
+	}
+}
+
+func list(cs procspy.ConnIter, err error) ([]procspy.Connection, error) {
+	if err != nil {
+		return nil, err
+	}
+	var res []procspy.Connection
+	for c := cs.Next(); c != nil; c = cs.Next() {
+		res = append(res, *c)
+	}
+	return res, cs.Err()
+}
+
+func TestFake(t *testing.T) {
+	var (
+		listen = conn(procspy.StateListen, 80, 12, "nginx")
+		est    = conn(procspy.StateEstablished, 80, 12, "nginx")
+		other  = conn(procspy.StateEstablished, 81, 13, "secret")
+		broken = errors.New("broken")
+	)
+	f := New(
+		Step{Connections: []procspy.Connection{listen, est}},
+		Step{Err: broken},
+	)
+	f.Push(Step{
+		Connections: []procspy.Connection{est, other},
+		IterErr:     broken,
+		Hidden:      []uint{13},
+	})
+	s := f.Scanner()
+
+	cs, err := list(s.Connections(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(cs), 1; have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	if have, want := cs[0].PID, uint(12); have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	if _, err := list(s.AllConnections(true)); err != broken {
+		t.Errorf("have %v, want %v", err, broken)
+	}
+
+	for i := 0; i < 2; i++ {
+		// The last step repeats.
+		cs, err = list(s.Connections(true))
+		if err != broken {
+			t.Errorf("have %v, want %v", err, broken)
+		}
+		if have, want := len(cs), 2; have != want {
+			t.Fatalf("have %d, want %d", have, want)
+		}
+		if have, want := cs[0].PID, uint(12); have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+		if have, want := cs[1].Proc, (procspy.Proc{}); have != want {
+			t.Errorf("have %v, want %v", have, want)
+		}
+	}
+
+	cs, _ = list(s.Connections(false))
+	if have, want := cs[0].Proc, (procspy.Proc{}); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := f.Calls(), 5; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
+
+func TestFakeDiff(t *testing.T) {
+	var (
+		a = conn(procspy.StateEstablished, 80, 12, "nginx")
+		b = conn(procspy.StateEstablished, 81, 12, "nginx")
+	)
+	f := New(
+		Step{Connections: []procspy.Connection{a}},
+		Step{Connections: []procspy.Connection{a, b}},
+	)
+	s := f.Scanner()
+	before, err := s.Snapshot(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.Snapshot(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := procspy.Diff(before, after)
+	if have, want := len(d.Added), 1; have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	if have, want := d.Added[0].LocalPort, uint16(81); have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	// Nothing global was changed.
+	if _, ok := procspy.CurrentBackend().(*Fake); ok {
+		t.Errorf("the fake leaked")
+	}
+}
@@ -0,0 +1,239 @@
+package procspy
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// Snapshot is the result of a full scan, with indexes for the common
+// lookups. Its connections are copies, so they don't change after the scan.
+type Snapshot struct {
+	Time time.Time
+	// Connections has all sockets, in every state, including listeners.
+	Connections []Connection
+	// Processes has all processes which own a socket, by PID.
+	Processes map[uint]Proc
+	// Namespaces has all network namespaces, sorted.
+	Namespaces []uint64
+
+	byPID        map[uint][]int
+	byLocalPort  map[uint16][]int
+	byRemoteAddr map[netip.Addr][]int
+	byName       map[string][]int
+	byNamespace  map[uint64][]int
+	byKey        map[Key][]int
+}
+
+// TakeSnapshot scans all sockets, in every state. If processes is true it'll
+// try to find the owning processes, just like Connections().
+func TakeSnapshot(processes bool) (*Snapshot, error) {
+	return (&Scanner{}).Snapshot(processes)
+}
+
+// NewSnapshot reads all connections from an iterator. Use this with, for
+// example, ProcCache.AllConnections().
+func NewSnapshot(cs ConnIter, t time.Time) (*Snapshot, error) {
+	var conns []Connection
+	for c := cs.Next(); c != nil; c = cs.Next() {
+		conns = append(conns, c.clone())
+	}
+	if err := cs.Err(); err != nil {
+		return nil, err
+	}
+	return newSnapshot(conns, t), nil
+}
+
+func newSnapshot(conns []Connection, t time.Time) *Snapshot {
+	s := &Snapshot{
+		Time:         t,
+		Connections:  conns,
+		Processes:    map[uint]Proc{},
+		byPID:        map[uint][]int{},
+		byLocalPort:  map[uint16][]int{},
+		byRemoteAddr: map[netip.Addr][]int{},
+		byName:       map[string][]int{},
+		byNamespace:  map[uint64][]int{},
+		byKey:        make(map[Key][]int, len(conns)),
+	}
+	for i := range conns {
+		c := &conns[i]
+		if c.PID != 0 {
+			s.Processes[c.PID] = c.Proc
+			s.byPID[c.PID] = append(s.byPID[c.PID], i)
+			s.byName[c.Name] = append(s.byName[c.Name], i)
+		}
+		s.byLocalPort[c.LocalPort] = append(s.byLocalPort[c.LocalPort], i)
+		if c.State != StateListen {
+			remote := c.Remote().Addr().WithZone("")
+			s.byRemoteAddr[remote] = append(s.byRemoteAddr[remote], i)
+		}
+		if _, ok := s.byNamespace[c.NetNS]; !ok {
+			s.Namespaces = append(s.Namespaces, c.NetNS)
+		}
+		s.byNamespace[c.NetNS] = append(s.byNamespace[c.NetNS], i)
+		s.byKey[c.Key()] = append(s.byKey[c.Key()], i)
+	}
+	sort.Slice(s.Namespaces, func(i, j int) bool {
+		return s.Namespaces[i] < s.Namespaces[j]
+	})
+	return s
+}
+
+// Listeners returns all listening sockets.
+func (s *Snapshot) Listeners() []Connection {
+	var res []Connection
+	for _, c := range s.Connections {
+		if c.State == StateListen {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// Get returns the connection with the given key. Sockets can share a key,
+// such as listeners with SO_REUSEPORT, then it's the first one. See ByKey().
+func (s *Snapshot) Get(k Key) (Connection, bool) {
+	is := s.byKey[k]
+	if len(is) == 0 {
+		return Connection{}, false
+	}
+	return s.Connections[is[0]], true
+}
+
+// ByKey returns all sockets with the given key.
+func (s *Snapshot) ByKey(k Key) []Connection {
+	return s.pick(s.byKey[k])
+}
+
+// ByPID returns all sockets owned by a process.
+func (s *Snapshot) ByPID(pid uint) []Connection {
+	return s.pick(s.byPID[pid])
+}
+
+// ByLocalPort returns all sockets with the given local port, including
+// listeners.
+func (s *Snapshot) ByLocalPort(port uint16) []Connection {
+	return s.pick(s.byLocalPort[port])
+}
+
+// ByRemoteAddr returns all connections to a remote address. IPv4-mapped IPv6
+// addresses match their IPv4 address, and zones are ignored.
+func (s *Snapshot) ByRemoteAddr(addr netip.Addr) []Connection {
+	return s.pick(s.byRemoteAddr[addr.Unmap().WithZone("")])
+}
+
+// ByProcessName returns all sockets owned by processes with the given name.
+func (s *Snapshot) ByProcessName(name string) []Connection {
+	return s.pick(s.byName[name])
+}
+
+// ByNamespace returns all sockets in a network namespace.
+func (s *Snapshot) ByNamespace(netns uint64) []Connection {
+	return s.pick(s.byNamespace[netns])
+}
+
+func (s *Snapshot) pick(is []int) []Connection {
+	if len(is) == 0 {
+		return nil
+	}
+	res := make([]Connection, 0, len(is))
+	for _, i := range is {
+		res = append(res, s.Connections[i])
+	}
+	return res
+}
+
+// SnapshotDiff is the difference between two snapshots.
+type SnapshotDiff struct {
+	Added   []Connection
+	Removed []Connection
+	Changed []ConnectionChange
+}
+
+// ConnectionChange is a connection which is in both snapshots, but with a
+// different state or owner.
+type ConnectionChange struct {
+	Old, New Connection
+}
+
+// Diff compares an older to a newer snapshot. Connections are matched by
+// their Key(). When sockets share a key they're matched by their inode, then
+// with an unchanged socket, and then in order. Sockets with different inodes
+// are never the same. Both snapshots can be nil.
+func Diff(before, after *Snapshot) SnapshotDiff {
+	var d SnapshotDiff
+	if before == nil {
+		before = &Snapshot{}
+	}
+	if after == nil {
+		after = &Snapshot{}
+	}
+	var (
+		pairs  = map[int]int{} // index in after -> index in before
+		paired = map[int]bool{}
+	)
+	for k, is := range after.byKey {
+		matchSockets(before.Connections, after.Connections, before.byKey[k], is, pairs, paired)
+	}
+	for i, c := range after.Connections {
+		j, ok := pairs[i]
+		if !ok {
+			d.Added = append(d.Added, c)
+			continue
+		}
+		if o := before.Connections[j]; o.State != c.State || o.Proc != c.Proc {
+			d.Changed = append(d.Changed, ConnectionChange{Old: o, New: c})
+		}
+	}
+	for j, c := range before.Connections {
+		if !paired[j] {
+			d.Removed = append(d.Removed, c)
+		}
+	}
+	return d
+}
+
+// matchSockets pairs the sockets with the same key in two snapshots.
+func matchSockets(before, after []Connection, bis, ais []int, pairs map[int]int, paired map[int]bool) {
+	free := append([]int(nil), bis...)
+	take := func(ai int, match func(a, b *Connection) bool) bool {
+		a := &after[ai]
+		for n, bi := range free {
+			b := &before[bi]
+			if a.inode != 0 && b.inode != 0 && a.inode != b.inode {
+				continue
+			}
+			if match(a, b) {
+				pairs[ai] = bi
+				paired[bi] = true
+				free = append(free[:n], free[n+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+	rounds := []func(a, b *Connection) bool{
+		func(a, b *Connection) bool { return a.inode != 0 && a.inode == b.inode },
+		func(a, b *Connection) bool { return a.State == b.State && a.Proc == b.Proc },
+		func(a, b *Connection) bool { return true },
+	}
+	for _, match := range rounds {
+		var rest []int
+		for _, ai := range ais {
+			if !take(ai, match) {
+				rest = append(rest, ai)
+			}
+		}
+		ais = rest
+	}
+}
+
+// clone copies a connection, including the IPs.
+func (c *Connection) clone() Connection {
+	cp := *c
+	cp.LocalAddress = append(net.IP(nil), c.LocalAddress...)
+	cp.RemoteAddress = append(net.IP(nil), c.RemoteAddress...)
+	return cp
+}
@@ -0,0 +1,171 @@
+package procspy
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testConnection(state State, local, remote string, netns uint64, p Proc) Connection {
+	l, r := netip.MustParseAddrPort(local), netip.MustParseAddrPort(remote)
+	return Connection{
+		Transport:     "tcp",
+		LocalAddress:  net.IP(l.Addr().AsSlice()),
+		LocalPort:     l.Port(),
+		RemoteAddress: net.IP(r.Addr().AsSlice()),
+		RemotePort:    r.Port(),
+		State:         state,
+		NetNS:         netns,
+		Proc:          p,
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	var (
+		nginx = Proc{PID: 42, Name: "nginx"}
+		redis = Proc{PID: 43, Name: "redis"}
+		now   = time.Date(2015, 7, 16, 0, 0, 0, 0, time.UTC)
+	)
+	f := fixedConnIter{
+		testConnection(StateListen, "0.0.0.0:80", "0.0.0.0:0", 1, nginx),
+		testConnection(StateEstablished, "10.0.0.1:80", "192.0.2.1:50000", 1, nginx),
+		testConnection(StateEstablished, "[::ffff:10.0.0.1]:80", "[::ffff:192.0.2.1]:50001", 1, nginx),
+		testConnection(StateEstablished, "10.0.0.1:50002", "10.0.0.2:6379", 1, Proc{}),
+		testConnection(StateListen, "127.0.0.1:6379", "0.0.0.0:0", 2, redis),
+	}
+	s, err := NewSnapshot(&f, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if have, want := s.Time, now; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := len(s.Connections), 5; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := s.Processes, map[uint]Proc{42: nginx, 43: redis}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := s.Namespaces, []uint64{1, 2}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	for name, c := range map[string]struct {
+		have []Connection
+		want int
+	}{
+		"listeners":  {s.Listeners(), 2},
+		"pid 42":     {s.ByPID(42), 3},
+		"pid 1":      {s.ByPID(1), 0},
+		"port 80":    {s.ByLocalPort(80), 3},
+		"remote":     {s.ByRemoteAddr(netip.MustParseAddr("192.0.2.1")), 2},
+		"remote v6":  {s.ByRemoteAddr(netip.MustParseAddr("::ffff:192.0.2.1")), 2},
+		"remote any": {s.ByRemoteAddr(netip.MustParseAddr("0.0.0.0")), 0},
+		"redis":      {s.ByProcessName("redis"), 1},
+		"netns 1":    {s.ByNamespace(1), 4},
+	} {
+		if have := len(c.have); have != c.want {
+			t.Errorf("%s: have %d, want %d", name, have, c.want)
+		}
+	}
+
+	if _, ok := s.Get(s.Connections[1].Key()); !ok {
+		t.Errorf("connection not found")
+	}
+}
+
+func TestSnapshotCopies(t *testing.T) {
+	p := NewProcNet(fixture, tcpEstablished)
+	s, err := NewSnapshot(p, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(s.Connections), 4; have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	// ProcNet re-uses its buffers, the snapshot should not.
+	if s.Connections[0].Local() == s.Connections[3].Local() {
+		t.Errorf("connections share their IPs")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	var (
+		nginx = Proc{PID: 42, Name: "nginx"}
+		redis = Proc{PID: 43, Name: "redis"}
+	)
+	before := newSnapshot([]Connection{
+		testConnection(StateListen, "0.0.0.0:80", "0.0.0.0:0", 1, nginx),
+		testConnection(StateEstablished, "10.0.0.1:80", "192.0.2.1:50000", 1, nginx),
+		testConnection(StateEstablished, "10.0.0.1:80", "192.0.2.1:50001", 1, nginx),
+	}, time.Now())
+	after := newSnapshot([]Connection{
+		testConnection(StateListen, "0.0.0.0:80", "0.0.0.0:0", 1, nginx),
+		testConnection(StateTimeWait, "10.0.0.1:80", "192.0.2.1:50000", 1, Proc{}),
+		testConnection(StateListen, "127.0.0.1:6379", "0.0.0.0:0", 2, redis),
+	}, time.Now())
+
+	d := Diff(before, after)
+	if have, want := len(d.Added), 1; have != want {
+		t.Fatalf("have %d added, want %d", have, want)
+	}
+	if have, want := d.Added[0].Proc, redis; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := len(d.Removed), 1; have != want {
+		t.Fatalf("have %d removed, want %d", have, want)
+	}
+	if have, want := d.Removed[0].RemotePort, uint16(50001); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := len(d.Changed), 1; have != want {
+		t.Fatalf("have %d changed, want %d", have, want)
+	}
+	if have, want := d.Changed[0].Old.State, StateEstablished; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := d.Changed[0].New.State, StateTimeWait; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	if have, want := len(Diff(nil, after).Added), 3; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := len(Diff(before, nil).Removed), 3; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
+
+func TestDiffSharedKey(t *testing.T) {
+	var (
+		a = testConnection(StateListen, "0.0.0.0:80", "0.0.0.0:0", 1, Proc{PID: 42, Name: "nginx"})
+		b = a
+		c = a
+	)
+	a.inode, b.inode, c.inode = 1000, 1001, 1002
+	b.Proc = Proc{PID: 43, Name: "nginx"}
+	c.Proc = Proc{PID: 44, Name: "nginx"}
+
+	before := newSnapshot([]Connection{a, b}, time.Now())
+	if have, want := before.ByKey(a.Key()), []Connection{a, b}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+	if d := Diff(before, newSnapshot([]Connection{b, a}, time.Now())); len(d.Added)+len(d.Removed)+len(d.Changed) != 0 {
+		t.Errorf("have %+v", d)
+	}
+
+	// b is replaced by c
+	d := Diff(before, newSnapshot([]Connection{c, a}, time.Now()))
+	if have, want := d.Added, []Connection{c}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+	if have, want := d.Removed, []Connection{b}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+	if len(d.Changed) != 0 {
+		t.Errorf("have %+v", d.Changed)
+	}
+}
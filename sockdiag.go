@@ -0,0 +1,111 @@
+package procspy
+
+// Decoding of sock_diag netlink replies. See include/uapi/linux/netlink.h,
+// include/uapi/linux/sock_diag.h, and include/uapi/linux/inet_diag.h.
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+)
+
+const (
+	nlmsgNoop  = 1
+	nlmsgError = 2
+	nlmsgDone  = 3
+
+	nlmFRequest = 0x1
+	nlmFDump    = 0x300
+
+	sockDiagByFamily = 20
+
+	afInet     = 2
+	afInet6    = 10 // Linux's, not Darwin's
+	ipprotoTCP = 6
+
+	inetDiagReqLen = 56 // struct inet_diag_req_v2
+	inetDiagMsgLen = 72 // struct inet_diag_msg
+)
+
+var errShortInetDiag = errors.New("short sock_diag message")
+
+// inetDiagRequest is a dump request for all TCP sockets of a family, in the
+// states of the bitmask.
+func inetDiagRequest(family uint8, states uint32, order binary.ByteOrder) []byte {
+	msg := make([]byte, nlmsgHdrLen+inetDiagReqLen)
+	order.PutUint32(msg, uint32(len(msg)))
+	order.PutUint16(msg[4:], sockDiagByFamily)
+	order.PutUint16(msg[6:], nlmFRequest|nlmFDump)
+	req := msg[nlmsgHdrLen:]
+	req[0] = family
+	req[1] = ipprotoTCP
+	order.PutUint32(req[4:], states)
+	return msg
+}
+
+// parseInetDiag decodes all sockets in a netlink datagram. done is true after
+// the last message of the dump. Addresses and ports are in network byte
+// order, everything else in the byte order of the kernel. The IPs are new
+// slices.
+func parseInetDiag(b []byte, order binary.ByteOrder) (cs []Connection, done bool, err error) {
+	for len(b) > 0 {
+		if len(b) < nlmsgHdrLen {
+			return cs, false, errShortInetDiag
+		}
+		l := int(order.Uint32(b))
+		if l < nlmsgHdrLen || l > len(b) {
+			return cs, false, errShortInetDiag
+		}
+		typ := order.Uint16(b[4:])
+		msg := b[nlmsgHdrLen:l]
+		// Messages are 4-byte aligned.
+		if l = (l + 3) &^ 3; l > len(b) {
+			l = len(b)
+		}
+		b = b[l:]
+
+		switch typ {
+		case nlmsgNoop:
+			continue
+		case nlmsgDone:
+			return cs, true, nil
+		case nlmsgError:
+			if len(msg) < 4 {
+				return cs, false, errShortInetDiag
+			}
+			if errno := int32(order.Uint32(msg)); errno != 0 {
+				return cs, false, syscall.Errno(-errno)
+			}
+			continue
+		case sockDiagByFamily:
+		default:
+			continue
+		}
+
+		if len(msg) < inetDiagMsgLen {
+			return cs, false, errShortInetDiag
+		}
+		c := Connection{
+			Transport:  "tcp",
+			State:      State(msg[1]),
+			LocalPort:  binary.BigEndian.Uint16(msg[4:]),
+			RemotePort: binary.BigEndian.Uint16(msg[6:]),
+			RecvQ:      order.Uint32(msg[56:]),
+			SendQ:      order.Uint32(msg[60:]),
+			inode:      uint64(order.Uint32(msg[68:])),
+		}
+		switch msg[0] {
+		case afInet:
+			c.LocalAddress = net.IP(append([]byte(nil), msg[8:12]...))
+			c.RemoteAddress = net.IP(append([]byte(nil), msg[24:28]...))
+		case afInet6:
+			c.LocalAddress = net.IP(append([]byte(nil), msg[8:24]...))
+			c.RemoteAddress = net.IP(append([]byte(nil), msg[24:40]...))
+		default:
+			continue
+		}
+		cs = append(cs, c)
+	}
+	return cs, false, nil
+}
@@ -0,0 +1,50 @@
+package procspy
+
+import (
+	"os"
+	"syscall"
+)
+
+// sockDiag dumps the TCP sockets of our own network namespace which are in
+// one of the states, a bitmask with 1<<state set.
+func sockDiag(states uint32) ([]Connection, error) {
+	fd, err := syscall.Socket(
+		syscall.AF_NETLINK,
+		syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC,
+		syscall.NETLINK_INET_DIAG, // NETLINK_SOCK_DIAG
+	)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(fd)
+
+	var (
+		res []Connection
+		buf = make([]byte, 32*1024)
+	)
+	for _, family := range []uint8{afInet, afInet6} {
+		if err := syscall.Sendto(fd, inetDiagRequest(family, states, nativeEndian), 0, &syscall.SockaddrNetlink{
+			Family: syscall.AF_NETLINK,
+		}); err != nil {
+			return nil, os.NewSyscallError("sendto", err)
+		}
+		for {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == syscall.EINTR {
+					continue
+				}
+				return nil, os.NewSyscallError("recvfrom", err)
+			}
+			cs, done, err := parseInetDiag(buf[:n], nativeEndian)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, cs...)
+			if done {
+				break
+			}
+		}
+	}
+	return res, nil
+}
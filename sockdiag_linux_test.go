@@ -0,0 +1,75 @@
+package procspy
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+)
+
+func TestNetlink(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	find := func(b Backend, all bool) map[Key]Connection {
+		cs, err := b.Connections(true, all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := map[Key]Connection{}
+		for c := cs.Next(); c != nil; c = cs.Next() {
+			res[c.Key()] = c.clone()
+		}
+		if err := cs.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, all := range []bool{false, true} {
+		var (
+			procfs  = find(ProcFS{}, all)
+			netlink = find(Netlink{}, all)
+		)
+		if all {
+			// Our listener.
+			k := Key{
+				Transport: "tcp",
+				NetNS:     selfNetNS(defaultFiles),
+				Local:     l.Addr().(*net.TCPAddr).AddrPort(),
+				Remote:    netip.MustParseAddrPort("0.0.0.0:0"),
+			}
+			c, ok := netlink[k]
+			if !ok {
+				t.Fatalf("listener not found")
+			}
+			if have, want := c.State, StateListen; have != want {
+				t.Errorf("have %v, want %v", have, want)
+			}
+		}
+		k := Key{
+			Transport: "tcp",
+			NetNS:     selfNetNS(defaultFiles),
+			Local:     client.LocalAddr().(*net.TCPAddr).AddrPort(),
+			Remote:    client.RemoteAddr().(*net.TCPAddr).AddrPort(),
+		}
+		c, ok := netlink[k]
+		if !ok {
+			t.Fatalf("connection not found")
+		}
+		if have, want := c.PID, uint(os.Getpid()); have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+		if have, want := c, procfs[k]; have.Key() != want.Key() || have.inode != want.inode || have.Proc != want.Proc {
+			t.Errorf("have %+v, want %+v", have, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package procspy
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// inetDiagMsg builds a single sock_diag reply message.
+func inetDiagMsg(order binary.ByteOrder, family uint8, state State, local, remote string, lport, rport uint16, inode uint32) []byte {
+	msg := make([]byte, nlmsgHdrLen+inetDiagMsgLen)
+	order.PutUint32(msg, uint32(len(msg)))
+	order.PutUint16(msg[4:], sockDiagByFamily)
+	m := msg[nlmsgHdrLen:]
+	m[0] = family
+	m[1] = uint8(state)
+	binary.BigEndian.PutUint16(m[4:], lport)
+	binary.BigEndian.PutUint16(m[6:], rport)
+	l, r := net.ParseIP(local), net.ParseIP(remote)
+	if family == afInet {
+		l, r = l.To4(), r.To4()
+	}
+	copy(m[8:], l)
+	copy(m[24:], r)
+	order.PutUint32(m[68:], inode)
+	return msg
+}
+
+func TestParseInetDiag(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var b []byte
+		b = append(b, inetDiagMsg(order, afInet, StateEstablished, "10.0.0.1", "10.0.0.2", 80, 40000, 1234)...)
+		order.PutUint32(b[nlmsgHdrLen+56:], 3) // idiag_rqueue
+		order.PutUint32(b[nlmsgHdrLen+60:], 7) // idiag_wqueue
+		b = append(b, inetDiagMsg(order, afInet6, StateListen, "::1", "::", 8080, 0, 1235)...)
+		cs, done, err := parseInetDiag(b, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done {
+			t.Errorf("done already")
+		}
+		expected := []Connection{
+			{
+				Transport:     "tcp",
+				State:         StateEstablished,
+				LocalAddress:  net.ParseIP("10.0.0.1").To4(),
+				LocalPort:     80,
+				RemoteAddress: net.ParseIP("10.0.0.2").To4(),
+				RemotePort:    40000,
+				RecvQ:         3,
+				SendQ:         7,
+				inode:         1234,
+			},
+			{
+				Transport:     "tcp",
+				State:         StateListen,
+				LocalAddress:  net.ParseIP("::1"),
+				LocalPort:     8080,
+				RemoteAddress: net.ParseIP("::"),
+				inode:         1235,
+			},
+		}
+		if !reflect.DeepEqual(cs, expected) {
+			t.Errorf("have\n%+v\nwant\n%+v", cs, expected)
+		}
+
+		for i := range b {
+			// Never panics.
+			parseInetDiag(b[:i], order)
+		}
+	}
+
+	order := binary.LittleEndian
+	done := make([]byte, nlmsgHdrLen+4)
+	order.PutUint32(done, uint32(len(done)))
+	order.PutUint16(done[4:], nlmsgDone)
+	if _, d, err := parseInetDiag(done, order); err != nil || !d {
+		t.Errorf("have %v %v, want done", d, err)
+	}
+
+	errMsg := make([]byte, nlmsgHdrLen+4)
+	order.PutUint32(errMsg, uint32(len(errMsg)))
+	order.PutUint16(errMsg[4:], nlmsgError)
+	errno := -int32(syscall.EPERM)
+	order.PutUint32(errMsg[nlmsgHdrLen:], uint32(errno))
+	if _, _, err := parseInetDiag(errMsg, order); err != syscall.EPERM {
+		t.Errorf("have %v, want %v", err, syscall.EPERM)
+	}
+}
@@ -4,7 +4,9 @@
 package procspy
 
 import (
+	"io"
 	"net"
+	"net/netip"
 )
 
 const (
@@ -12,25 +14,95 @@ const (
 )
 
 // Connection is a (TCP) connection. The Proc struct might not be filled in.
+// The IPs might be re-used by the iterator, use Local() and Remote() if you
+// want to keep them.
 type Connection struct {
 	Transport     string
 	LocalAddress  net.IP
 	LocalPort     uint16
 	RemoteAddress net.IP
 	RemotePort    uint16
+	State         State
+	RecvQ         uint32 // bytes in the receive queue, when known
+	SendQ         uint32 // bytes in the send queue, when known
+	NetNS         uint64 // inode of the network namespace. Linux only.
 	inode         uint64
+	localZone     string // IPv6 zone, when known
+	remoteZone    string
 	Proc
 }
 
+// Local returns the local address and port. IPv4-mapped IPv6 addresses are
+// returned as IPv4 addresses.
+func (c *Connection) Local() netip.AddrPort {
+	return addrPort(c.LocalAddress, c.localZone, c.LocalPort)
+}
+
+// Remote returns the remote address and port. IPv4-mapped IPv6 addresses are
+// returned as IPv4 addresses.
+func (c *Connection) Remote() netip.AddrPort {
+	return addrPort(c.RemoteAddress, c.remoteZone, c.RemotePort)
+}
+
+// Key returns the key of this connection.
+func (c *Connection) Key() Key {
+	return Key{
+		Transport: c.Transport,
+		NetNS:     c.NetNS,
+		Local:     c.Local(),
+		Remote:    c.Remote(),
+	}
+}
+
+// Key identifies a connection. Keys are comparable, so they can be used as
+// map keys.
+type Key struct {
+	Transport string
+	NetNS     uint64
+	Local     netip.AddrPort
+	Remote    netip.AddrPort
+}
+
+func addrPort(ip net.IP, zone string, port uint16) netip.AddrPort {
+	addr, _ := netip.AddrFromSlice(ip)
+	addr = addr.Unmap()
+	if zone != "" && addr.Is6() {
+		addr = addr.WithZone(zone)
+	}
+	return netip.AddrPortFrom(addr, port)
+}
+
 // Proc is a single process with PID and process name.
 type Proc struct {
-	PID  uint
-	Name string
+	PID  uint   `json:"pid"`
+	Name string `json:"name"`
 }
 
-// ConnIter is returned by Connections().
+// ConnIter is returned by Connections(). Some iterators keep files open until
+// Next() returned nil; they implement io.Closer. Use CloseIter() when you stop
+// early.
 type ConnIter interface {
+	// Next returns the next connection, or nil when there are no more.
 	Next() *Connection
+	// Err returns the first error encountered while iterating, if any. Check
+	// it after Next() returned nil.
+	Err() error
+}
+
+// CloseIter closes an iterator, if it's an io.Closer. Closing an iterator
+// which is done already is fine.
+func CloseIter(it ConnIter) error {
+	if c, ok := it.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// SliceIter iterates over connections, such as those of a Snapshot. It gives
+// copies, so the slice doesn't change.
+func SliceIter(cs []Connection) ConnIter {
+	it := fixedConnIter(cs)
+	return &it
 }
 
 // Connections returns all established (TCP) connections.  If processes is
@@ -39,5 +111,11 @@ type ConnIter interface {
 // connection, filling in the Proc field. You will need to run this as root to
 // find all processes.
 func Connections(processes bool) (ConnIter, error) {
-	return cbConnections(processes)
+	return CurrentBackend().Connections(processes, false)
+}
+
+// AllConnections is like Connections(), but it lists TCP sockets in every
+// state, including listening sockets.
+func AllConnections(processes bool) (ConnIter, error) {
+	return CurrentBackend().Connections(processes, true)
 }
@@ -11,22 +11,24 @@ const (
 	lsofBinary    = "lsof"
 )
 
-// Connections returns all established (TCP) connections. No need to be root
-// to run this. If processes is true it also tries to fill in the process
-// fields of the connection. You need to be root to find all processes.
-var cbConnections = func(processes bool) (ConnIter, error) {
-	out, err := exec.Command(
-		netstatBinary,
-		"-n", // no number resolving
-		"-W", // Wide output
-		// "-l", // full IPv6 addresses // What does this do?
-		"-p", "tcp", // only TCP
-	).CombinedOutput()
+// Netstat runs netstat, and lsof for the processes. No need to be root to run
+// this. You need to be root to find all processes.
+type Netstat struct{}
+
+var defaultBackend Backend = Netstat{}
+
+func init() {
+	RegisterBackend("netstat", Netstat{})
+}
+
+// Connections implements Backend.
+func (Netstat) Connections(processes, all bool) (ConnIter, error) {
+	out, err := exec.Command(netstatBinary, netstatArgs(all)...).CombinedOutput()
 	if err != nil {
 		// log.Printf("lsof error: %s", err)
 		return nil, err
 	}
-	connections := parseDarwinNetstat(string(out))
+	connections := parseDarwinNetstat(string(out), all)
 
 	if processes {
 		out, err := exec.Command(
@@ -60,3 +62,14 @@ var cbConnections = func(processes bool) (ConnIter, error) {
 	f := fixedConnIter(connections)
 	return &f, nil
 }
+
+// Connections is the same as the package level Connections(). There are no
+// processes to cache on Darwin.
+func (c *ProcCache) Connections(processes bool) (ConnIter, error) {
+	return Netstat{}.Connections(processes, false)
+}
+
+// AllConnections is the same as the package level AllConnections().
+func (c *ProcCache) AllConnections(processes bool) (ConnIter, error) {
+	return Netstat{}.Connections(processes, true)
+}
@@ -1,58 +1,152 @@
 package procspy
 
 import (
-	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"sync"
 )
 
-var bufPool = sync.Pool{
+var readerPool = sync.Pool{
 	New: func() interface{} {
-		return bytes.NewBuffer(make([]byte, 0, 5000))
+		return NewProcNetReader(nil, tcpEstablished)
 	},
 }
 
+// pnConnIter reads the tcp tables one after the other. A broken table doesn't
+// stop the iteration, but it's reported by Err(). It keeps the current table
+// open until Next() returns nil, or until Close().
 type pnConnIter struct {
-	pn    *ProcNet
-	buf   *bytes.Buffer
-	procs map[uint64]Proc
+	pn     *ProcNetReader
+	files  procFiles
+	f      io.ReadCloser // current table, if any
+	table  netTable
+	tables []netTable
+	procs  map[uint64]Proc
+	err    error
+}
+
+func (c *pnConnIter) Err() error {
+	return c.err
 }
 
 func (c *pnConnIter) Next() *Connection {
-	n := c.pn.Next()
-	if n == nil {
-		// Done!
-		bufPool.Put(c.buf)
-		return nil
+	for {
+		if c.f != nil {
+			if n := c.pn.Next(); n != nil {
+				n.Transport = "tcp"
+				n.NetNS = c.table.netns
+				// The parser re-uses n, so always set this.
+				n.Proc = c.procs[n.inode]
+				return n
+			}
+			if err := c.pn.Err(); err != nil && c.err == nil {
+				c.err = fmt.Errorf("%s: %w", c.table.path, err)
+			}
+			c.f.Close()
+			c.f = nil
+		}
+
+		if len(c.tables) == 0 {
+			// Done!
+			c.Close()
+			return nil
+		}
+
+		c.table = c.tables[0]
+		c.tables = c.tables[1:]
+		f, err := openFile(c.files, c.table.path)
+		if err != nil {
+			continue
+		}
+		c.f = f
+		c.pn.Reset(f)
 	}
-	if proc, ok := c.procs[n.inode]; ok {
-		n.Proc = proc
+}
+
+// Close closes the open table, if any. Next() returns nil after a Close().
+func (c *pnConnIter) Close() error {
+	var err error
+	if c.f != nil {
+		err = c.f.Close()
+		c.f = nil
+	}
+	c.tables = nil
+	if c.pn != nil {
+		c.pn.Reset(nil)
+		readerPool.Put(c.pn)
+		c.pn = nil
 	}
-	return n
+	return err
 }
 
-// cbConnections sets Connections()
-var cbConnections = func(processes bool) (ConnIter, error) {
-	// We read /proc/<pid>/net/tcp once per netns
-	netns := map[uint64]struct{}{}
-	buf := bufPool.Get().(*bytes.Buffer)
-	buf.Reset()
+// Connections is like the package level Connections(), but processes which
+// didn't change since the previous call aren't scanned again.
+func (c *ProcCache) Connections(processes bool) (ConnIter, error) {
+	return procConnections(defaultFiles, nil, processes, false, c)
+}
 
-	var procs map[uint64]Proc
+// AllConnections is like the package level AllConnections(), but processes
+// which didn't change since the previous call aren't scanned again.
+func (c *ProcCache) AllConnections(processes bool) (ConnIter, error) {
+	return procConnections(defaultFiles, nil, processes, true, c)
+}
+
+// procConnections reads the connections from /proc. order and cache can be
+// nil.
+func procConnections(files procFiles, order binary.ByteOrder, processes, all bool, cache *ProcCache) (ConnIter, error) {
+	// We read /proc/<pid>/net/tcp once per netns
+	var (
+		netns  = map[uint64]struct{}{}
+		tables []netTable
+		procs  map[uint64]Proc
+	)
 	if processes {
 		var err error
-		if procs, err = walkProcPid(&netns, buf); err != nil {
+		if procs, err = walkProcPid(files, &netns, &tables, cache); err != nil {
 			return nil, err
 		}
 	}
 
 	if len(netns) == 0 {
-		readFile(procRoot+"/net/tcp", buf)
-		readFile(procRoot+"/net/tcp6", buf)
+		tables = selfTables(files)
 	}
 
+	return newPnConnIter(files, tables, procs, all, order), nil
+}
+
+// newPnConnIter reads the tables, in order. If all is false only established
+// connections are returned. order is the byte order of the tables, nil is
+// the byte order of this machine.
+func newPnConnIter(files procFiles, tables []netTable, procs map[uint64]Proc, all bool, order binary.ByteOrder) *pnConnIter {
+	pn := readerPool.Get().(*ProcNetReader)
+	pn.wantedState = tcpEstablished
+	if all {
+		pn.wantedState = 0
+	}
+	if order == nil {
+		order = nativeEndian
+	}
+	pn.SetByteOrder(order)
 	return &pnConnIter{
-		pn:    NewProcNet(buf.Bytes(), tcpEstablished),
-		buf:   buf,
-		procs: procs,
-	}, nil
+		pn:     pn,
+		files:  files,
+		tables: tables,
+		procs:  procs,
+	}
+}
+
+// selfTables are the tables of our own network namespace.
+func selfTables(files procFiles) []netTable {
+	ns := selfNetNS(files)
+	return []netTable{
+		{"net/tcp", ns},
+		{"net/tcp6", ns},
+	}
+}
+
+// selfNetNS is the inode of our own network namespace, or 0 if we can't tell.
+func selfNetNS(files procFiles) uint64 {
+	ns, _ := files.inode("self/ns/net", false)
+	return ns
 }
@@ -0,0 +1,144 @@
+package procspy
+
+import (
+	"io"
+	"net/netip"
+	"syscall"
+	"testing"
+)
+
+func TestConnectionsProcRoot(t *testing.T) {
+	root := t.TempDir()
+	inodes := makeProcTree(t, root, []fakeProc{
+		{pid: 42, name: "nginx", start: 100, netns: "a", sockets: 2},
+		{pid: 43, name: "redis", start: 200, netns: "a", sockets: 1},
+		{pid: 44, name: "envoy", start: 300, netns: "b", sockets: 1},
+	})
+	defer SetProcRoot(procRoot)
+	SetProcRoot(root)
+
+	nsInode := func(name string) uint64 {
+		var stat syscall.Stat_t
+		if err := syscall.Stat(root+"/netns/"+name, &stat); err != nil {
+			t.Fatal(err)
+		}
+		return stat.Ino
+	}
+	owners := map[uint64]uint{}
+	for pid, is := range inodes {
+		for _, inode := range is {
+			owners[inode] = uint(pid)
+		}
+	}
+
+	cs, err := Connections(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		keys  = map[Key]Proc{}
+		found = 0
+	)
+	for c := cs.Next(); c != nil; c = cs.Next() {
+		if have, want := c.Transport, "tcp"; have != want {
+			t.Errorf("have %q, want %q", have, want)
+		}
+		if pid, ok := owners[c.inode]; ok {
+			found++
+			if have, want := c.PID, pid; have != want {
+				t.Errorf("have %d, want %d", have, want)
+			}
+			ns := "a"
+			if pid == 44 {
+				ns = "b"
+			}
+			if have, want := c.NetNS, nsInode(ns); have != want {
+				t.Errorf("pid %d: have netns %d, want %d", pid, have, want)
+			}
+		}
+		keys[c.Key()] = c.Proc
+	}
+	if err := cs.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := found, 4; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	// The fixture is in both namespaces, and the sockets have unique ports.
+	if have, want := len(keys), 2*4+4; have != want {
+		t.Errorf("have %d keys, want %d", have, want)
+	}
+	k := Key{
+		Transport: "tcp",
+		NetNS:     nsInode("b"),
+		Local:     netip.MustParseAddrPort("127.0.0.1:10704"),
+		Remote:    netip.MustParseAddrPort("127.0.0.1:8080"),
+	}
+	if have, want := keys[k].Name, "envoy"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+// openFiles counts the files which are open.
+type openFiles struct {
+	procFiles
+	n int
+}
+
+func (f *openFiles) open(name string) (io.ReadCloser, error) {
+	r, err := f.procFiles.open(name)
+	if err != nil {
+		return nil, err
+	}
+	f.n++
+	return &countedFile{ReadCloser: r, f: f}, nil
+}
+
+type countedFile struct {
+	io.ReadCloser
+	f *openFiles
+}
+
+func (c *countedFile) Close() error {
+	c.f.n--
+	return c.ReadCloser.Close()
+}
+
+func TestPnConnIterClose(t *testing.T) {
+	root := t.TempDir()
+	makeProcTree(t, root, []fakeProc{
+		{pid: 42, name: "nginx", start: 100, netns: "a", sockets: 2},
+	})
+	defer SetProcRoot(procRoot)
+	SetProcRoot(root)
+
+	files := &openFiles{procFiles: defaultFiles}
+	it := newPnConnIter(files, selfTables(files), nil, true, nil)
+	if it.Next() == nil {
+		t.Fatal("no connections")
+	}
+	if have, want := files.n, 1; have != want {
+		t.Fatalf("have %d open files, want %d", have, want)
+	}
+	// Stop early.
+	if err := CloseIter(it); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := files.n, 0; have != want {
+		t.Errorf("have %d open files, want %d", have, want)
+	}
+	if it.Next() != nil {
+		t.Errorf("connections after Close()")
+	}
+	if err := CloseIter(it); err != nil {
+		t.Errorf("second close: %s", err)
+	}
+
+	// To the end.
+	it = newPnConnIter(files, selfTables(files), nil, true, nil)
+	for c := it.Next(); c != nil; c = it.Next() {
+	}
+	if have, want := files.n, 0; have != want {
+		t.Errorf("have %d open files, want %d", have, want)
+	}
+}
@@ -0,0 +1,43 @@
+package procspy
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestConnectionAddrPort(t *testing.T) {
+	c := Connection{
+		Transport:     "tcp",
+		LocalAddress:  net.ParseIP("10.0.0.1"), // 16 bytes, IPv4-mapped
+		LocalPort:     80,
+		RemoteAddress: net.ParseIP("fe80::1"),
+		RemotePort:    50000,
+		remoteZone:    "eth0",
+	}
+	if have, want := c.Local(), netip.MustParseAddrPort("10.0.0.1:80"); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := c.Remote(), netip.MustParseAddrPort("[fe80::1%eth0]:50000"); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// The same connection, with IPv4 addresses as 4 bytes, has the same key.
+	c2 := c
+	c2.LocalAddress = net.IP{10, 0, 0, 1}
+	if c.Key() != c2.Key() {
+		t.Errorf("keys differ: %v, %v", c.Key(), c2.Key())
+	}
+
+	// Changing the IP doesn't change the key.
+	k := c2.Key()
+	c2.LocalAddress[3] = 2
+	if have, want := k.Local, netip.MustParseAddrPort("10.0.0.1:80"); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// No address.
+	if have, want := (&Connection{}).Local(), netip.AddrPortFrom(netip.Addr{}, 0); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
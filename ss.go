@@ -0,0 +1,104 @@
+package procspy
+
+// ss(8) reading.
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// parseSS parses the output of `ss -tanp`, with or without the header. If
+// all is false only established connections are returned. The process is
+// only there if ss could see it, and if a socket is shared by more processes
+// it's the first one.
+func parseSS(out string, all bool) []Connection {
+	//
+	// State  Recv-Q Send-Q Local Address:Port  Peer Address:Port Process
+	// ESTAB  0      0      127.0.0.1:52370    127.0.0.1:34501   users:(("python3",pid=16067,fd=6))
+	// LISTEN 1      128        [::1]:32867         [::]:*
+	//
+	var res []Connection
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		state, ok := ssStates[fields[0]]
+		if !ok || (!all && state != StateEstablished) {
+			// This skips the header as well.
+			continue
+		}
+
+		c := Connection{
+			Transport: "tcp",
+			State:     state,
+			RecvQ:     parseQueue(fields[1]),
+			SendQ:     parseQueue(fields[2]),
+		}
+		var ok1, ok2 bool
+		c.LocalAddress, c.localZone, c.LocalPort, ok1 = parseSSAddr(fields[3])
+		c.RemoteAddress, c.remoteZone, c.RemotePort, ok2 = parseSSAddr(fields[4])
+		if !ok1 || !ok2 {
+			continue
+		}
+		if len(fields) > 5 {
+			c.Proc = parseSSUsers(strings.Join(fields[5:], " "))
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// parseSSAddr parses "1.2.3.4:80", "[::1]:80", "[fe80::1]%eth0:22", and
+// "*:*".
+func parseSSAddr(s string) (net.IP, string, uint16, bool) {
+	i := strings.LastIndexByte(s, ':')
+	if i == -1 {
+		return nil, "", 0, false
+	}
+	host, port := s[:i], s[i+1:]
+	p, err := parsePort(port)
+	if err != nil || p > 65535 {
+		return nil, "", 0, false
+	}
+	var zone string
+	if j := strings.LastIndexByte(host, '%'); j != -1 {
+		host, zone = host[:j], host[j+1:]
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "*" {
+		return net.IPv6unspecified, zone, uint16(p), true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, "", 0, false
+	}
+	return ip, zone, uint16(p), true
+}
+
+// parseSSUsers takes the first process from `users:(("name",pid=12,fd=3))`.
+func parseSSUsers(s string) Proc {
+	const prefix = `users:(("`
+	i := strings.Index(s, prefix)
+	if i == -1 {
+		return Proc{}
+	}
+	s = s[i+len(prefix):]
+	i = strings.Index(s, `",pid=`)
+	if i == -1 {
+		return Proc{}
+	}
+	name, s := s[:i], s[i+len(`",pid=`):]
+	if i = strings.IndexAny(s, ",)"); i != -1 {
+		s = s[:i]
+	}
+	pid, err := strconv.ParseUint(s, 10, 0)
+	if err != nil {
+		return Proc{}
+	}
+	return Proc{
+		PID:  uint(pid),
+		Name: name,
+	}
+}
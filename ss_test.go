@@ -0,0 +1,74 @@
+package procspy
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseSS(t *testing.T) {
+	out := `State  Recv-Q Send-Q Local Address:Port  Peer Address:Port Process
+LISTEN 1      128    127.0.0.1:34501   0.0.0.0:*     users:(("python3",pid=16067,fd=5))
+ESTAB  0      0      127.0.0.1:52370 127.0.0.1:34501 users:(("python3",pid=16067,fd=6),("python3",pid=16068,fd=6))
+ESTAB  5      17     127.0.0.1:34501 127.0.0.1:52370
+ESTAB  0      0          [::1]:35544     [::1]:32867 users:(("my, \"odd\" name",pid=12,fd=4))
+ESTAB  0      0      [fe80::1]%eth0:22 [fe80::2]%eth0:50000
+LISTEN 0      128            *:22             *:*
+ESTAB  0      0      127.0.0.1:nonsense 127.0.0.1:52370
+`
+	res := parseSS(out, false)
+	expected := []Connection{
+		{
+			Transport:     "tcp",
+			State:         StateEstablished,
+			LocalAddress:  net.ParseIP("127.0.0.1"),
+			LocalPort:     52370,
+			RemoteAddress: net.ParseIP("127.0.0.1"),
+			RemotePort:    34501,
+			Proc:          Proc{PID: 16067, Name: "python3"},
+		},
+		{
+			Transport:     "tcp",
+			State:         StateEstablished,
+			LocalAddress:  net.ParseIP("127.0.0.1"),
+			LocalPort:     34501,
+			RemoteAddress: net.ParseIP("127.0.0.1"),
+			RemotePort:    52370,
+			RecvQ:         5,
+			SendQ:         17,
+		},
+		{
+			Transport:     "tcp",
+			State:         StateEstablished,
+			LocalAddress:  net.ParseIP("::1"),
+			LocalPort:     35544,
+			RemoteAddress: net.ParseIP("::1"),
+			RemotePort:    32867,
+			Proc:          Proc{PID: 12, Name: `my, \"odd\" name`},
+		},
+		{
+			Transport:     "tcp",
+			State:         StateEstablished,
+			LocalAddress:  net.ParseIP("fe80::1"),
+			localZone:     "eth0",
+			LocalPort:     22,
+			RemoteAddress: net.ParseIP("fe80::2"),
+			remoteZone:    "eth0",
+			RemotePort:    50000,
+		},
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("have\n%+v\nwant\n%+v", res, expected)
+	}
+
+	res = parseSS(out, true)
+	if have, want := len(res), 6; have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	if have, want := res[0].State, StateListen; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := res[5].Local().String(), "[::]:22"; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
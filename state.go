@@ -0,0 +1,104 @@
+package procspy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// State is the state of a TCP socket, as in include/net/tcp_states.h.
+type State uint8
+
+// The TCP states.
+const (
+	StateEstablished State = iota + 1
+	StateSynSent
+	StateSynRecv
+	StateFinWait1
+	StateFinWait2
+	StateTimeWait
+	StateClose
+	StateCloseWait
+	StateLastAck
+	StateListen
+	StateClosing
+	StateNewSynRecv
+)
+
+var stateNames = []string{
+	StateEstablished: "ESTABLISHED",
+	StateSynSent:     "SYN_SENT",
+	StateSynRecv:     "SYN_RECV",
+	StateFinWait1:    "FIN_WAIT1",
+	StateFinWait2:    "FIN_WAIT2",
+	StateTimeWait:    "TIME_WAIT",
+	StateClose:       "CLOSE",
+	StateCloseWait:   "CLOSE_WAIT",
+	StateLastAck:     "LAST_ACK",
+	StateListen:      "LISTEN",
+	StateClosing:     "CLOSING",
+	StateNewSynRecv:  "NEW_SYN_RECV",
+}
+
+// String gives the name Linux uses for the state, such as "ESTABLISHED".
+func (s State) String() string {
+	if int(s) < len(stateNames) && stateNames[s] != "" {
+		return stateNames[s]
+	}
+	return "UNKNOWN(" + strconv.Itoa(int(s)) + ")"
+}
+
+// darwinStates are the state names netstat uses on Darwin.
+var darwinStates = map[string]State{
+	"ESTABLISHED": StateEstablished,
+	"SYN_SENT":    StateSynSent,
+	"SYN_RCVD":    StateSynRecv,
+	"FIN_WAIT_1":  StateFinWait1,
+	"FIN_WAIT_2":  StateFinWait2,
+	"TIME_WAIT":   StateTimeWait,
+	"CLOSED":      StateClose,
+	"CLOSE_WAIT":  StateCloseWait,
+	"LAST_ACK":    StateLastAck,
+	"LISTEN":      StateListen,
+	"CLOSING":     StateClosing,
+}
+
+// ssStates are the state names of ss(8).
+var ssStates = map[string]State{
+	"ESTAB":        StateEstablished,
+	"SYN-SENT":     StateSynSent,
+	"SYN-RECV":     StateSynRecv,
+	"FIN-WAIT-1":   StateFinWait1,
+	"FIN-WAIT-2":   StateFinWait2,
+	"TIME-WAIT":    StateTimeWait,
+	"UNCONN":       StateClose,
+	"CLOSE-WAIT":   StateCloseWait,
+	"LAST-ACK":     StateLastAck,
+	"LISTEN":       StateListen,
+	"CLOSING":      StateClosing,
+	"NEW-SYN-RECV": StateNewSynRecv,
+}
+
+// MarshalText gives the same as String().
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText parses the output of String().
+func (s *State) UnmarshalText(b []byte) error {
+	name := string(b)
+	for i, n := range stateNames {
+		if n != "" && n == name {
+			*s = State(i)
+			return nil
+		}
+	}
+	if strings.HasPrefix(name, "UNKNOWN(") && strings.HasSuffix(name, ")") {
+		n, err := strconv.ParseUint(name[len("UNKNOWN("):len(name)-1], 10, 8)
+		if err == nil {
+			*s = State(n)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid state %q", name)
+}
@@ -0,0 +1,489 @@
+package procspy
+
+// An on-disk history of snapshots.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoSnapshot is returned by Store.At() when there is no snapshot that old.
+var ErrNoSnapshot = errors.New("no snapshot")
+
+// segmentSuffix is the suffix of the files in a store. The name before it is
+// the time of the first snapshot, in nanoseconds since the epoch.
+const segmentSuffix = ".ndjson.gz"
+
+// Store keeps snapshots in a directory, to see what was connected when. Add
+// a snapshot every so often with Add(), and query them with At() and
+// Query(). Other processes can query the directory while one process adds.
+//
+// The snapshots are stored in segment files. Every segment starts with a full
+// snapshot, after which every snapshot is stored as the difference with the
+// one before it, each gzipped on its own. Retention removes whole segments,
+// the oldest first.
+type Store struct {
+	dir  string
+	opts StoreOptions
+
+	mu   sync.Mutex
+	cur  *os.File // segment we write to
+	size int64    // of cur
+	last time.Time
+	prev map[Key][]Connection // the previous snapshot
+}
+
+// StoreOptions are the options of OpenStore().
+type StoreOptions struct {
+	// MaxAge removes snapshots older than this. 0 keeps them.
+	MaxAge time.Duration
+	// MaxBytes removes the oldest snapshots when the store is bigger than
+	// this. 0 is no limit.
+	MaxBytes int64
+	// SegmentBytes starts a new segment after this many bytes. Defaults to
+	// an eighth of MaxBytes, or else to 4MB.
+	SegmentBytes int64
+}
+
+// storeRecord is a snapshot in a segment file. If Full is set it's a complete
+// snapshot, otherwise it's the changes since the snapshot before it.
+type storeRecord struct {
+	Version int          `json:"version"`
+	Time    time.Time    `json:"time"`
+	Full    bool         `json:"full,omitempty"`
+	Set     []Connection `json:"set,omitempty"` // all sockets of new and changed keys
+	Del     []storeKey   `json:"del,omitempty"`
+}
+
+// storeKey is how a Key looks in a segment file.
+type storeKey struct {
+	Transport string         `json:"transport"`
+	NetNS     uint64         `json:"netns,omitempty"`
+	Local     netip.AddrPort `json:"local"`
+	Remote    netip.AddrPort `json:"remote"`
+}
+
+// OpenStore opens a store, and makes the directory if it's not there. Close
+// it when done.
+func OpenStore(dir string, opts StoreOptions) (*Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 4 << 20
+		if opts.MaxBytes > 0 {
+			opts.SegmentBytes = opts.MaxBytes / 8
+		}
+	}
+	s := &Store{dir: dir, opts: opts}
+	segs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) > 0 {
+		// Add() goes on after the last snapshot of the newest segment.
+		seg := segs[len(segs)-1]
+		s.last = seg.start
+		if err := readSegment(seg.path, func(r *storeRecord) bool {
+			if r.Time.After(s.last) {
+				s.last = r.Time
+			}
+			return true
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Close closes the segment Add() writes to.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur == nil {
+		return nil
+	}
+	err := s.cur.Close()
+	s.cur = nil
+	return err
+}
+
+// Add stores a snapshot, and removes old ones. The snapshots must be added in
+// the order of their Time.
+func (s *Store) Add(snap *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !snap.Time.After(s.last) {
+		return fmt.Errorf("snapshot of %s is not after %s", snap.Time.Format(time.RFC3339Nano), s.last.Format(time.RFC3339Nano))
+	}
+
+	next := byKey(snap.Connections)
+	rec := storeRecord{Version: JSONVersion, Time: snap.Time}
+	if s.cur == nil || s.size >= s.opts.SegmentBytes {
+		if err := s.rotate(snap.Time); err != nil {
+			return err
+		}
+		rec.Full = true
+		rec.Set = snap.Connections
+	} else {
+		rec.Set, rec.Del = delta(s.prev, next)
+	}
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if err := json.NewEncoder(zw).Encode(rec); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	// A single write, so readers see the whole record or nothing at all.
+	if _, err := s.cur.Write(b.Bytes()); err != nil {
+		return err
+	}
+	s.size += int64(b.Len())
+	s.last = snap.Time
+	s.prev = next
+	return s.expire(snap.Time)
+}
+
+// rotate starts a new segment.
+func (s *Store) rotate(t time.Time) error {
+	if s.cur != nil {
+		if err := s.cur.Close(); err != nil {
+			return err
+		}
+		s.cur = nil
+	}
+	name := filepath.Join(s.dir, strconv.FormatInt(t.UnixNano(), 10)+segmentSuffix)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	s.cur, s.size = f, 0
+	return nil
+}
+
+// expire removes the segments which are too old, or too many. The segment we
+// write to stays.
+func (s *Store) expire(now time.Time) error {
+	segs, err := s.segments()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, seg := range segs {
+		total += seg.size
+	}
+	for len(segs) > 1 {
+		// A segment ends where the next one starts.
+		old := s.opts.MaxAge > 0 && !segs[1].start.After(now.Add(-s.opts.MaxAge))
+		big := s.opts.MaxBytes > 0 && total > s.opts.MaxBytes
+		if !old && !big {
+			break
+		}
+		if err := os.Remove(segs[0].path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		total -= segs[0].size
+		segs = segs[1:]
+	}
+	return nil
+}
+
+// byKey groups connections on their Key(). Sockets can share a key, such as
+// listeners with SO_REUSEPORT.
+func byKey(cs []Connection) map[Key][]Connection {
+	m := make(map[Key][]Connection, len(cs))
+	for _, c := range cs {
+		k := c.Key()
+		m[k] = append(m[k], c)
+	}
+	for _, cs := range m {
+		if len(cs) > 1 {
+			sortSockets(cs)
+		}
+	}
+	return m
+}
+
+// sortSockets sorts sockets with the same Key().
+func sortSockets(cs []Connection) {
+	sort.Slice(cs, func(i, j int) bool {
+		a, b := &cs[i], &cs[j]
+		if a.inode != b.inode {
+			return a.inode < b.inode
+		}
+		if a.State != b.State {
+			return a.State < b.State
+		}
+		return a.PID < b.PID
+	})
+}
+
+// delta gives what changed between two snapshots. When anything about a key
+// changed all its sockets are in the set.
+func delta(prev, next map[Key][]Connection) ([]Connection, []storeKey) {
+	var (
+		set []Connection
+		del []storeKey
+	)
+	for k, cs := range next {
+		if !sameSockets(prev[k], cs) {
+			set = append(set, cs...)
+		}
+	}
+	for k := range prev {
+		if _, ok := next[k]; !ok {
+			del = append(del, storeKey(k))
+		}
+	}
+	sort.SliceStable(set, func(i, j int) bool { return lessKey(set[i].Key(), set[j].Key()) })
+	sort.Slice(del, func(i, j int) bool { return lessKey(Key(del[i]), Key(del[j])) })
+	return set, del
+}
+
+// sameSockets compares the sorted sockets of a key.
+func sameSockets(a, b []Connection) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameConnection(&a[i], &b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameConnection compares two connections with the same Key().
+func sameConnection(a, b *Connection) bool {
+	return a.State == b.State &&
+		a.RecvQ == b.RecvQ &&
+		a.SendQ == b.SendQ &&
+		a.inode == b.inode &&
+		a.Proc == b.Proc
+}
+
+// segment is a segment file.
+type segment struct {
+	path  string
+	start time.Time
+	size  int64
+}
+
+// segments gives the segment files, the oldest first.
+func (s *Store) segments() ([]segment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []segment
+	for _, e := range entries {
+		ns, ok := strings.CutSuffix(e.Name(), segmentSuffix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		n, err := strconv.ParseInt(ns, 10, 64)
+		if err != nil {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			// removed since ReadDir()
+			continue
+		}
+		segs = append(segs, segment{
+			path:  filepath.Join(s.dir, e.Name()),
+			start: time.Unix(0, n),
+			size:  fi.Size(),
+		})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].start.Before(segs[j].start) })
+	return segs, nil
+}
+
+// readSegment reads the records of a segment, until fn returns false. A
+// record which is cut off ends the segment. A segment which was removed has
+// no records.
+func readSegment(path string, fn func(*storeRecord) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	dec := json.NewDecoder(zr)
+	for {
+		var rec storeRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := checkJSONVersion(rec.Version); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if !fn(&rec) {
+			return nil
+		}
+	}
+}
+
+// apply updates the connections with a record. The sockets of a key in the
+// set replace all the sockets which had that key.
+func (r *storeRecord) apply(state map[Key][]Connection) map[Key][]Connection {
+	if r.Full || state == nil {
+		state = make(map[Key][]Connection, len(r.Set))
+	}
+	for k, cs := range byKey(r.Set) {
+		state[k] = cs
+	}
+	for _, k := range r.Del {
+		delete(state, Key(k))
+	}
+	return state
+}
+
+// sortedConnections gives the connections sorted on their Key().
+func sortedConnections(state map[Key][]Connection) []Connection {
+	cs := make([]Connection, 0, len(state))
+	for _, ks := range state {
+		cs = append(cs, ks...)
+	}
+	sort.SliceStable(cs, func(i, j int) bool { return lessKey(cs[i].Key(), cs[j].Key()) })
+	return cs
+}
+
+// At gives the latest snapshot taken at or before t, or ErrNoSnapshot.
+func (s *Store) At(t time.Time) (*Snapshot, error) {
+	segs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(segs) - 1; i >= 0; i-- {
+		if segs[i].start.After(t) {
+			continue
+		}
+		var (
+			state map[Key][]Connection
+			when  time.Time
+		)
+		if err := readSegment(segs[i].path, func(r *storeRecord) bool {
+			if r.Time.After(t) {
+				return false
+			}
+			state = r.apply(state)
+			when = r.Time
+			return true
+		}); err != nil {
+			return nil, err
+		}
+		if state != nil {
+			return newSnapshot(sortedConnections(state), when), nil
+		}
+	}
+	return nil, ErrNoSnapshot
+}
+
+// Query gives every connection which matches f in a snapshot taken between
+// from and to, with when it was first and last seen in those snapshots. A zero
+// from or to is no limit, and a nil f matches everything. The connection is as
+// it was last seen, but with the last known owner. The result is sorted on
+// when connections were first seen.
+//
+// Filters which read /proc, with uid or container, are an error: the store
+// doesn't have what they need.
+func (s *Store) Query(from, to time.Time, f *Filter) ([]TrackedConnection, error) {
+	if f != nil && f.ReadsProc() {
+		return nil, errNoProc
+	}
+	segs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	// Sockets which share a key are told apart by their inode, or else by
+	// their order.
+	type socket struct {
+		key   Key
+		inode uint64
+		n     int
+	}
+	seen := map[socket]*TrackedConnection{}
+	for i, seg := range segs {
+		if !to.IsZero() && seg.start.After(to) {
+			break
+		}
+		if !from.IsZero() && i+1 < len(segs) && !segs[i+1].start.After(from) {
+			// all before from
+			continue
+		}
+		var state map[Key][]Connection
+		if err := readSegment(seg.path, func(r *storeRecord) bool {
+			if !to.IsZero() && r.Time.After(to) {
+				return false
+			}
+			state = r.apply(state)
+			if !from.IsZero() && r.Time.Before(from) {
+				return true
+			}
+			for k, cs := range state {
+				for i := range cs {
+					c := &cs[i]
+					sk := socket{key: k, inode: c.inode}
+					for j := 0; j < i; j++ {
+						if cs[j].inode == c.inode {
+							sk.n++
+						}
+					}
+					if f != nil && !f.Match(c) {
+						continue
+					}
+					tc, ok := seen[sk]
+					if !ok {
+						tc = &TrackedConnection{FirstSeen: r.Time}
+						seen[sk] = tc
+					}
+					owner := tc.Connection.Proc
+					tc.Connection = *c
+					tc.LastSeen = r.Time
+					if c.PID == 0 && owner.PID != 0 {
+						tc.Connection.Proc = owner
+					}
+				}
+			}
+			return true
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	res := make([]TrackedConnection, 0, len(seen))
+	for _, tc := range seen {
+		res = append(res, *tc)
+	}
+	sortTracked(res)
+	return res, nil
+}
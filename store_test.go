@@ -0,0 +1,271 @@
+package procspy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		start = time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+		curl  = Proc{PID: 42, Name: "curl"}
+		nginx = Proc{PID: 43, Name: "nginx"}
+
+		listen = testConnection(StateListen, "0.0.0.0:80", "0.0.0.0:0", 1, nginx)
+		in     = testConnection(StateEstablished, "10.0.0.1:80", "10.0.0.2:50000", 1, nginx)
+		out    = testConnection(StateEstablished, "10.0.0.1:40001", "10.0.0.3:443", 1, curl)
+	)
+	s, err := OpenStore(dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	outTW := out
+	outTW.State = StateTimeWait
+	outTW.Proc = Proc{}
+	outQ := out
+	outQ.SendQ = 1448
+	snaps := [][]Connection{
+		{listen},
+		{listen, in},
+		{listen, in, out},
+		{listen, outQ},
+		{listen, outTW},
+		{listen},
+	}
+	for i, cs := range snaps {
+		if err := s.Add(newSnapshot(cs, start.Add(time.Duration(i)*time.Minute))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Add(newSnapshot(nil, start)); err == nil {
+		t.Errorf("no error for an old snapshot")
+	}
+
+	t.Run("at", func(t *testing.T) {
+		for i, cs := range snaps {
+			at := start.Add(time.Duration(i)*time.Minute + 30*time.Second)
+			snap, err := s.At(at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := newSnapshot(sortedConnections(byKey(cs)), start.Add(time.Duration(i)*time.Minute))
+			if !reflect.DeepEqual(snap, want) {
+				t.Errorf("%d: have\n%+v\nwant\n%+v", i, snap.Connections, want.Connections)
+			}
+		}
+		if _, err := s.At(start.Add(-time.Second)); !errors.Is(err, ErrNoSnapshot) {
+			t.Errorf("have %v", err)
+		}
+	})
+
+	t.Run("query", func(t *testing.T) {
+		f, err := ParseFilter("process curl or rnet 10.0.0.3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := s.Query(time.Time{}, time.Time{}, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outTW.Proc = curl
+		want := []TrackedConnection{
+			{Connection: outTW, FirstSeen: start.Add(2 * time.Minute), LastSeen: start.Add(4 * time.Minute)},
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("have\n%+v\nwant\n%+v", have, want)
+		}
+
+		uid, err := ParseFilter("uid 0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Query(time.Time{}, time.Time{}, uid); err != errNoProc {
+			t.Errorf("have %v, want %v", err, errNoProc)
+		}
+
+		have, err = s.Query(start.Add(30*time.Second), start.Add(2*time.Minute), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = []TrackedConnection{
+			{Connection: listen, FirstSeen: start.Add(time.Minute), LastSeen: start.Add(2 * time.Minute)},
+			{Connection: in, FirstSeen: start.Add(time.Minute), LastSeen: start.Add(2 * time.Minute)},
+			{Connection: out, FirstSeen: start.Add(2 * time.Minute), LastSeen: start.Add(2 * time.Minute)},
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("have\n%+v\nwant\n%+v", have, want)
+		}
+	})
+
+	t.Run("cut off", func(t *testing.T) {
+		segs, err := s.segments()
+		if err != nil || len(segs) != 1 {
+			t.Fatalf("have %v, %v", segs, err)
+		}
+		f, err := os.OpenFile(segs[0].path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte{0x1f, 0x8b, 8, 0, 0})
+		f.Close()
+		snap, err := s.At(start.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := len(snap.Connections), 1; have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+	})
+
+	t.Run("reopen", func(t *testing.T) {
+		s, err := OpenStore(dir, StoreOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		last := start.Add(time.Duration(len(snaps)-1) * time.Minute)
+		for _, at := range []time.Time{last.Add(-30 * time.Second), last} {
+			if err := s.Add(newSnapshot(nil, at)); err == nil {
+				t.Errorf("no error for a snapshot of %s", at)
+			}
+		}
+		if err := s.Add(newSnapshot(nil, last.Add(time.Minute))); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestStoreSharedKey(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		start = time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+		a     = testConnection(StateListen, "0.0.0.0:80", "0.0.0.0:0", 1, Proc{PID: 42, Name: "nginx"})
+		b     = a
+	)
+	a.inode, b.inode = 1000, 1001
+	b.Proc = Proc{PID: 43, Name: "nginx"}
+	s, err := OpenStore(dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	snaps := [][]Connection{
+		{a, b},
+		{b, a},
+		{a},
+		{a, b},
+	}
+	for i, cs := range snaps {
+		if err := s.Add(newSnapshot(cs, start.Add(time.Duration(i)*time.Minute))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, want := range [][]Connection{{a, b}, {a, b}, {a}, {a, b}} {
+		snap, err := s.At(start.Add(time.Duration(i) * time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := snap.Connections; !reflect.DeepEqual(have, want) {
+			t.Errorf("%d: have\n%+v\nwant\n%+v", i, have, want)
+		}
+	}
+
+	have, err := s.Query(time.Time{}, time.Time{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TrackedConnection{
+		{Connection: a, FirstSeen: start, LastSeen: start.Add(3 * time.Minute)},
+		{Connection: b, FirstSeen: start, LastSeen: start.Add(3 * time.Minute)},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", have, want)
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		start = time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+		nginx = Proc{PID: 43, Name: "nginx"}
+	)
+	// A segment per snapshot.
+	s, err := OpenStore(dir, StoreOptions{MaxAge: 10 * time.Minute, SegmentBytes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		c := testConnection(StateEstablished, "10.0.0.1:80", "10.0.0.2:50000", 1, nginx)
+		c.RemotePort += uint16(i)
+		if err := s.Add(newSnapshot([]Connection{c}, start.Add(time.Duration(i)*time.Minute))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	segs, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if have, want := len(segs), 11; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if _, err := s.At(start.Add(18 * time.Minute)); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("have %v", err)
+	}
+	if _, err := s.At(start.Add(19 * time.Minute)); err != nil {
+		t.Errorf("have %v", err)
+	}
+
+	// Reopen, and limit the bytes.
+	s, err = OpenStore(dir, StoreOptions{MaxBytes: 1000, SegmentBytes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Add(newSnapshot(nil, start.Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	segs, _ = filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	for _, seg := range segs {
+		fi, _ := os.Stat(seg)
+		total += fi.Size()
+	}
+	if total > 1000 || len(segs) < 2 {
+		t.Errorf("%d bytes in %d segments", total, len(segs))
+	}
+}
+
+func TestStoreDelta(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		start = time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+		nginx = Proc{PID: 43, Name: "nginx"}
+		cs    []Connection
+	)
+	for i := 0; i < 200; i++ {
+		c := testConnection(StateEstablished, "10.0.0.1:80", "10.0.0.2:50000", 1, nginx)
+		c.RemotePort += uint16(i)
+		cs = append(cs, c)
+	}
+	s, err := OpenStore(dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Add(newSnapshot(cs, start)); err != nil {
+		t.Fatal(err)
+	}
+	full := s.size
+	// One connection less.
+	if err := s.Add(newSnapshot(cs[1:], start.Add(time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	if d := s.size - full; d*4 > full {
+		t.Errorf("delta of %d bytes, full is %d bytes", d, full)
+	}
+}
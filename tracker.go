@@ -0,0 +1,269 @@
+package procspy
+
+// Following connections over repeated scans.
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tracker follows connections over repeated scans: when they were first and
+// last seen, how long the ones which are gone lived, and how often processes
+// open new ones. Give it every scan with Update().
+//
+// A connection is an established socket, identified by its Key() and its
+// inode. It's closed when a scan doesn't have it, or has it in another state,
+// such as TIME_WAIT or CLOSE_WAIT. Connections which open and close between
+// two scans are never seen, and durations are only as precise as the scan
+// interval. Connections which are there in the first scan were opened before
+// the tracker started, and don't count as opened. The zero value is ready to
+// use.
+type Tracker struct {
+	// Now is the clock. Defaults to time.Now.
+	Now func() time.Time
+	// Window is how far back Rates() looks. Defaults to a minute.
+	Window time.Duration
+
+	mu    sync.Mutex
+	start time.Time // first Update()
+	live  map[Key]*TrackedConnection
+	opens map[Proc][]time.Time
+}
+
+// TrackedConnection is a connection which is still there.
+type TrackedConnection struct {
+	Connection Connection `json:"connection"` // as last seen
+	FirstSeen  time.Time  `json:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen"`
+}
+
+// ConnectionRecord is a connection which is gone.
+type ConnectionRecord struct {
+	// Connection is as it was last seen, in the state it went to if the scan
+	// which closed it has it, such as TIME_WAIT. The owner is the last known
+	// one, a socket in TIME_WAIT has no owner anymore.
+	Connection Connection `json:"connection"`
+	FirstSeen  time.Time  `json:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen"` // the last scan it was established
+	Closed     time.Time  `json:"closed"`    // the first scan it wasn't
+	// Duration is from FirstSeen to Closed.
+	Duration time.Duration `json:"duration"`
+}
+
+// ProcessRate is how many connections a process opened within the window.
+type ProcessRate struct {
+	Proc      Proc    `json:"process"` // no PID if the owner is unknown
+	Opened    int     `json:"opened"`
+	PerSecond float64 `json:"per_second"`
+}
+
+func (t *Tracker) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+func (t *Tracker) window() time.Duration {
+	if t.Window > 0 {
+		return t.Window
+	}
+	return time.Minute
+}
+
+// Update adds a scan, and gives the connections which closed since the
+// previous scan, sorted on when they were first seen. Only established
+// sockets are followed. A nil snapshot, such as of a failed scan, changes
+// nothing.
+func (t *Tracker) Update(s *Snapshot) []ConnectionRecord {
+	if s == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	first := t.live == nil
+	if first {
+		t.start = now
+		t.live = map[Key]*TrackedConnection{}
+		t.opens = map[Proc][]time.Time{}
+	}
+	var closed []ConnectionRecord
+	// end closes a connection. last is the socket in its new state, if any.
+	end := func(k Key, tc *TrackedConnection, last *Connection) {
+		c := tc.Connection
+		if last != nil {
+			c = last.clone()
+			if c.PID == 0 {
+				c.Proc = tc.Connection.Proc
+			}
+		}
+		closed = append(closed, ConnectionRecord{
+			Connection: c,
+			FirstSeen:  tc.FirstSeen,
+			LastSeen:   tc.LastSeen,
+			Closed:     now,
+			Duration:   now.Sub(tc.FirstSeen),
+		})
+		delete(t.live, k)
+	}
+	seen := make(map[Key]bool, len(s.Connections))
+	for i := range s.Connections {
+		c := &s.Connections[i]
+		k := c.Key()
+		tc, ok := t.live[k]
+		if c.State != StateEstablished {
+			if ok && !seen[k] && sameSocket(&tc.Connection, c) {
+				end(k, tc, c)
+			}
+			continue
+		}
+		if seen[k] {
+			// There can only be one established socket with a key.
+			continue
+		}
+		seen[k] = true
+		if ok && !sameSocket(&tc.Connection, c) {
+			// The addresses are used again, by a new socket.
+			end(k, tc, nil)
+			ok = false
+		}
+		if !ok {
+			tc = &TrackedConnection{FirstSeen: now}
+			t.live[k] = tc
+			if !first {
+				t.opens[c.Proc] = append(t.opens[c.Proc], now)
+			}
+		}
+		owner := tc.Connection.Proc
+		tc.Connection = c.clone()
+		tc.LastSeen = now
+		if c.PID == 0 && owner.PID != 0 {
+			tc.Connection.Proc = owner
+		}
+	}
+	for k, tc := range t.live {
+		if !seen[k] {
+			end(k, tc, nil)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool {
+		a, b := closed[i], closed[j]
+		if !a.FirstSeen.Equal(b.FirstSeen) {
+			return a.FirstSeen.Before(b.FirstSeen)
+		}
+		return lessKey(a.Connection.Key(), b.Connection.Key())
+	})
+
+	t.expire(now)
+	return closed
+}
+
+// expire forgets opens which are older than the window.
+func (t *Tracker) expire(now time.Time) {
+	since := now.Add(-t.window())
+	for p, ts := range t.opens {
+		i := sort.Search(len(ts), func(i int) bool { return ts[i].After(since) })
+		if i == len(ts) {
+			delete(t.opens, p)
+			continue
+		}
+		t.opens[p] = ts[i:]
+	}
+}
+
+// Live gives the connections which were in the last scan, sorted on when
+// they were first seen.
+func (t *Tracker) Live() []TrackedConnection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make([]TrackedConnection, 0, len(t.live))
+	for _, tc := range t.live {
+		res = append(res, *tc)
+	}
+	sortTracked(res)
+	return res
+}
+
+// Get gives a connection which was in the last scan.
+func (t *Tracker) Get(k Key) (TrackedConnection, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tc, ok := t.live[k]
+	if !ok {
+		return TrackedConnection{}, false
+	}
+	return *tc, true
+}
+
+// Rates gives how many connections every process opened within the window,
+// the busiest first. Before the tracker has run for a full window the rate is
+// over the time it did run.
+func (t *Tracker) Rates() []ProcessRate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.expire(now)
+	span := t.window()
+	if d := now.Sub(t.start); d < span {
+		span = d
+	}
+	var res []ProcessRate
+	for p, ts := range t.opens {
+		r := ProcessRate{Proc: p, Opened: len(ts)}
+		if span > 0 {
+			r.PerSecond = float64(len(ts)) / span.Seconds()
+		}
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Opened != b.Opened {
+			return a.Opened > b.Opened
+		}
+		if a.Proc.Name != b.Proc.Name {
+			return a.Proc.Name < b.Proc.Name
+		}
+		return a.Proc.PID < b.Proc.PID
+	})
+	return res
+}
+
+// sameSocket is whether two sockets with the same Key() are the same one, as
+// far as we can tell. Sockets in TIME_WAIT have no inode.
+func sameSocket(a, b *Connection) bool {
+	return a.inode == 0 || b.inode == 0 || a.inode == b.inode
+}
+
+// sortTracked sorts on when connections were first seen.
+func sortTracked(tcs []TrackedConnection) {
+	sort.Slice(tcs, func(i, j int) bool {
+		a, b := tcs[i], tcs[j]
+		if !a.FirstSeen.Equal(b.FirstSeen) {
+			return a.FirstSeen.Before(b.FirstSeen)
+		}
+		if ka, kb := a.Connection.Key(), b.Connection.Key(); ka != kb {
+			return lessKey(ka, kb)
+		}
+		// sockets sharing a key
+		if a.Connection.inode != b.Connection.inode {
+			return a.Connection.inode < b.Connection.inode
+		}
+		return a.Connection.PID < b.Connection.PID
+	})
+}
+
+func lessKey(a, b Key) bool {
+	if a.Transport != b.Transport {
+		return a.Transport < b.Transport
+	}
+	if a.NetNS != b.NetNS {
+		return a.NetNS < b.NetNS
+	}
+	if a.Local != b.Local {
+		return lessAP(a.Local, b.Local)
+	}
+	return lessAP(a.Remote, b.Remote)
+}
@@ -0,0 +1,145 @@
+package procspy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	var (
+		now   = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		tr    = &Tracker{Now: func() time.Time { return now }, Window: time.Minute}
+		curl  = Proc{PID: 42, Name: "curl"}
+		nginx = Proc{PID: 43, Name: "nginx"}
+		snap  = func(cs ...Connection) *Snapshot { return newSnapshot(cs, now) }
+
+		listen = testConnection(StateListen, "0.0.0.0:80", "0.0.0.0:0", 1, nginx)
+		old    = testConnection(StateEstablished, "10.0.0.1:80", "10.0.0.2:50000", 1, nginx)
+		c1     = testConnection(StateEstablished, "10.0.0.1:40001", "10.0.0.3:443", 1, curl)
+		c2     = testConnection(StateEstablished, "10.0.0.1:40002", "10.0.0.3:443", 1, curl)
+		in     = testConnection(StateEstablished, "10.0.0.1:80", "10.0.0.4:50001", 1, nginx)
+	)
+	start := now
+
+	if closed := tr.Update(snap(listen, old)); len(closed) != 0 {
+		t.Fatalf("closed: %+v", closed)
+	}
+
+	now = now.Add(10 * time.Second)
+	if closed := tr.Update(snap(listen, old, c1, in)); len(closed) != 0 {
+		t.Fatalf("closed: %+v", closed)
+	}
+	if tc, ok := tr.Get(c1.Key()); !ok || !tc.FirstSeen.Equal(now) || !tc.LastSeen.Equal(now) {
+		t.Errorf("have %+v, %t", tc, ok)
+	}
+
+	// c1 in TIME_WAIT, without an owner, is closed.
+	now = now.Add(10 * time.Second)
+	tw := c1
+	tw.State = StateTimeWait
+	tw.Proc = Proc{}
+	closed := tr.Update(snap(listen, old, tw, c2, in))
+	tw.Proc = curl
+	want := []ConnectionRecord{
+		{
+			Connection: tw,
+			FirstSeen:  start.Add(10 * time.Second),
+			LastSeen:   start.Add(10 * time.Second),
+			Closed:     now,
+			Duration:   10 * time.Second,
+		},
+	}
+	if !reflect.DeepEqual(closed, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", closed, want)
+	}
+	if have, want := len(tr.Live()), 3; have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	// A failed scan.
+	if closed := tr.Update(nil); len(closed) != 0 {
+		t.Fatalf("closed: %+v", closed)
+	}
+
+	now = now.Add(10 * time.Second)
+	closed = tr.Update(snap(listen, c2))
+	want = []ConnectionRecord{
+		{
+			Connection: old,
+			FirstSeen:  start,
+			LastSeen:   start.Add(20 * time.Second),
+			Closed:     now,
+			Duration:   30 * time.Second,
+		},
+		{
+			Connection: in,
+			FirstSeen:  start.Add(10 * time.Second),
+			LastSeen:   start.Add(20 * time.Second),
+			Closed:     now,
+			Duration:   20 * time.Second,
+		},
+	}
+	if !reflect.DeepEqual(closed, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", closed, want)
+	}
+	live := tr.Live()
+	if len(live) != 1 || live[0].Connection.Key() != c2.Key() {
+		t.Errorf("live: %+v", live)
+	}
+
+	// 30s in, old doesn't count.
+	rates := tr.Rates()
+	wantRates := []ProcessRate{
+		{Proc: curl, Opened: 2, PerSecond: 2.0 / 30},
+		{Proc: nginx, Opened: 1, PerSecond: 1.0 / 30},
+	}
+	if !reflect.DeepEqual(rates, wantRates) {
+		t.Errorf("have %+v, want %+v", rates, wantRates)
+	}
+
+	// Only c2 is within the window.
+	now = start.Add(75 * time.Second)
+	rates = tr.Rates()
+	wantRates = []ProcessRate{
+		{Proc: curl, Opened: 1, PerSecond: 1.0 / 60},
+	}
+	if !reflect.DeepEqual(rates, wantRates) {
+		t.Errorf("have %+v, want %+v", rates, wantRates)
+	}
+}
+
+func TestTrackerReuse(t *testing.T) {
+	var (
+		now  = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		tr   = &Tracker{Now: func() time.Time { return now }}
+		curl = Proc{PID: 42, Name: "curl"}
+		snap = func(cs ...Connection) *Snapshot { return newSnapshot(cs, now) }
+		a    = testConnection(StateEstablished, "10.0.0.1:40001", "10.0.0.3:443", 1, curl)
+		b    = a
+	)
+	start := now
+	a.inode, b.inode = 1000, 1001
+	tr.Update(snap(a))
+
+	// The same addresses, but another socket.
+	now = now.Add(10 * time.Second)
+	closed := tr.Update(snap(b))
+	want := []ConnectionRecord{
+		{
+			Connection: a,
+			FirstSeen:  start,
+			LastSeen:   start,
+			Closed:     now,
+			Duration:   10 * time.Second,
+		},
+	}
+	if !reflect.DeepEqual(closed, want) {
+		t.Errorf("have\n%+v\nwant\n%+v", closed, want)
+	}
+	if tc, ok := tr.Get(b.Key()); !ok || tc.Connection.inode != 1001 || !tc.FirstSeen.Equal(now) {
+		t.Errorf("have %+v, %t", tc, ok)
+	}
+	if rates := tr.Rates(); len(rates) != 1 || rates[0].Opened != 1 {
+		t.Errorf("have %+v", rates)
+	}
+}